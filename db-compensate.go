@@ -0,0 +1,59 @@
+package go_migrator
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+)
+
+// appliedMigration - миграция, успешно выполненная в текущем запуске Migrate.
+type appliedMigration struct {
+	model     models.MigrationModel
+	migration *Migration
+}
+
+// compensate откатывает миграции типа TypeVersioned, выполненные в текущем запуске, в обратном порядке и
+// восстанавливает сохраненную версию, предшествовавшую запуску. Если в запуске была выполнена миграция типа
+// TypeBaseline, версия восстанавливается до версии baseline, т.к. такие миграции не откатываются.
+// При ошибке отката оставшиеся миграции не откатываются, а сохраненной становится версия миграции, которую
+// не удалось откатить.
+func (m *MigrationManager) compensate(
+	db, stateDB *gorm.DB,
+	applied []appliedMigration,
+	versionBefore Version,
+	cause error,
+) error {
+	m.logger.Println("Rolling back migrations applied in current run")
+
+	compensationErr := &CompensationError{Cause: cause}
+	versionToSave := versionBefore
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		migrationModel, migration := applied[i].model, applied[i].migration
+
+		if migration.migrationType == TypeBaseline {
+			versionToSave = mustParseVersion(migrationModel.Version)
+			break
+		}
+
+		err := m.executeDowngrade(db, migrationModel, migration)
+		if err == nil {
+			err = repository.UpdateMigrationStateExecuted(stateDB, &migrationModel, models.StateUndone, migration.checksum)
+		}
+		if err != nil {
+			compensationErr.CompensationErrors = append(compensationErr.CompensationErrors, err)
+			versionToSave = mustParseVersion(migrationModel.Version)
+			break
+		}
+
+		compensationErr.Compensated = append(compensationErr.Compensated, migrationModel.Version)
+	}
+
+	err := repository.SaveVersion(stateDB, versionToSave.String())
+	if err != nil {
+		compensationErr.CompensationErrors = append(compensationErr.CompensationErrors, err)
+	}
+
+	m.logger.Println("Rollback completed, current repository version is", versionToSave)
+	return compensationErr
+}
@@ -1,145 +1,426 @@
 package go_migrator
 
 import (
+	"context"
 	"fmt"
 	"github.com/MashinIvan/go-migrator/internal/models"
 	"github.com/MashinIvan/go-migrator/internal/repository"
-	"sort"
+	"gorm.io/gorm"
 )
 
 // Downgrade осуществляет отмену успешно выполненных или пропущенных миграций в обратном порядке.
 // Миграции типа TypeRepeatable и TypeBaseline не отменяются.
 // Новые миграции при вызове Downgrade не сохраняются.
 //
-// Паникует в случае, если какая-либо из миграций не была найдена.
-func (m *MigrationManager) Downgrade() (err error) {
-	m.logger.Println("Preparing downgrade execution")
+// Если задан Locker (см. WithLocker), операция выполняется под блокировкой.
+//
+// Перед откатом первой миграции план проверяется целиком, при наличии препятствий возвращается
+// *DowngradeBlockedError со списком всех препятствий.
+// Возвращает ErrNoSystemTables, если системные таблицы не созданы, и *MigrationExecutionError при ошибке отката
+// миграции.
+func (m *MigrationManager) Downgrade() error {
+	return m.DowngradeContext(context.Background())
+}
+
+// DowngradeContext выполняет Downgrade с учетом контекста. При отмене контекста выполнение прекращается перед
+// следующей миграцией. Прерванная отменой миграция, как и в MigrateContext, сохраняет прежнее состояние.
+func (m *MigrationManager) DowngradeContext(ctx context.Context) error {
+	return m.DowngradeToContext(ctx, m.targetVersion)
+}
+
+// DowngradeTo выполняет Downgrade до версии version вместо target версии, заданной при создании управляющего.
+// Возвращает ErrTargetVersionRequired, если версия не задана.
+func (m *MigrationManager) DowngradeTo(version string) error {
+	return m.DowngradeToContext(context.Background(), version)
+}
+
+// DowngradeToContext выполняет DowngradeTo с учетом контекста (см. DowngradeContext).
+func (m *MigrationManager) DowngradeToContext(ctx context.Context, version string) error {
+	targetVersion, err := m.resolveDowngradeTargetVersion(version)
+	if err != nil {
+		return err
+	}
+
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.downgrade(ctx, targetVersion, 0)
+	})
+}
+
+// DowngradeSteps откатывает не более steps последних успешно выполненных или пропущенных миграций типа
+// TypeVersioned в порядке убывания версий, после чего версия в таблице version соответствует версии, предшествующей
+// последней откаченной миграции. Target версия, заданная при создании управляющего, не учитывается.
+// Возвращает ErrInvalidStepCount, если steps не положительно.
+func (m *MigrationManager) DowngradeSteps(steps int) error {
+	return m.DowngradeStepsContext(context.Background(), steps)
+}
+
+// DowngradeStepsContext выполняет DowngradeSteps с учетом контекста (см. DowngradeContext).
+func (m *MigrationManager) DowngradeStepsContext(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidStepCount, steps)
+	}
+
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.downgrade(ctx, Version{}, steps)
+	})
+}
+
+// downgrade выполняет план отката до версии targetVersion. Если steps не равно нулю, откатывается не более steps
+// миграций, а targetVersion не учитывается.
+func (m *MigrationManager) downgrade(ctx context.Context, targetVersion Version, steps int) error {
+	if steps != 0 {
+		m.logger.Println("Preparing downgrade execution, step limit:", steps)
+	} else {
+		m.logger.Println("Preparing downgrade execution, target version:", targetVersion)
+	}
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkGroupTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := m.primaryDB(ctx)
+
+	if !repository.HasVersionTable(db) || !repository.HasMigrationsTable(db) {
+		return ErrNoSystemTables
+	}
 
-	if !repository.HasVersionTable(m.db) || !repository.HasVersionTable(m.db) {
-		panic("No migration table or version table found. Cannot perform downgrade")
+	err = repository.UpgradeMigrationsTable(db)
+	if err != nil {
+		return err
 	}
 
-	savedMigrations, err := repository.GetMigrationsSorted(m.db, repository.OrderDESC)
+	err = m.recoverInterruptedMigrations(db)
 	if err != nil {
 		return err
 	}
 
-	plan, err := m.planDowngrade()
+	if m.groupTransaction {
+		m.logger.Println("Executing downgrade inside group transaction")
+	}
+	err = m.execute(ctx, db, func(db, stateDB *gorm.DB) error {
+		return m.executeDowngradePlan(ctx, db, stateDB, targetVersion, steps)
+	})
 	if err != nil {
 		return err
 	}
 
+	m.logger.Println("Downgrade completed")
+	return nil
+}
+
+// executeDowngradePlan строит план отката и выполняет его. Состояния миграций после отката сохраняются через stateDB.
+// Если steps не равно нулю, план ограничивается steps миграциями (см. limitDowngradeSteps).
+func (m *MigrationManager) executeDowngradePlan(
+	ctx context.Context,
+	db, stateDB *gorm.DB,
+	targetVersion Version,
+	steps int,
+) error {
+	savedMigrations, plan, _, err := m.prepareDowngradePlan(db, targetVersion, steps)
+	if err != nil {
+		return err
+	}
+
+	return m.runDowngradePlan(ctx, db, stateDB, savedMigrations, plan)
+}
+
+// prepareDowngradePlan строит и проверяет план отката. Возвращает сохраненные миграции в порядке убывания ранга,
+// план и версию, которая будет сохранена после его выполнения.
+func (m *MigrationManager) prepareDowngradePlan(
+	db *gorm.DB,
+	targetVersion Version,
+	steps int,
+) ([]models.MigrationModel, migrationsPlan, Version, error) {
+	savedMigrations, err := m.loadSavedMigrations(db, repository.OrderDESC)
+	if err != nil {
+		return nil, migrationsPlan{}, Version{}, err
+	}
+
+	migrationsToPlan, err := m.saveNewMigrations(db)
+	if err != nil {
+		return nil, migrationsPlan{}, Version{}, err
+	}
+
+	plan, err := m.planDowngrade(db, migrationsToPlan, targetVersion)
+	if err != nil {
+		return nil, migrationsPlan{}, Version{}, err
+	}
+	if steps != 0 {
+		plan, targetVersion, err = m.limitDowngradeSteps(db, plan, savedMigrations, steps)
+		if err != nil {
+			return nil, migrationsPlan{}, Version{}, err
+		}
+	}
+
+	err = m.validateDowngradePlan(plan, savedMigrations, targetVersion)
+	if err != nil {
+		return nil, migrationsPlan{}, Version{}, err
+	}
+
+	return savedMigrations, plan, targetVersion, nil
+}
+
+// limitDowngradeSteps ограничивает план отката, построенный до нулевой версии, steps миграциями и возвращает версию,
+// которая будет сохранена после его выполнения (см. plannedDowngradeVersion).
+func (m *MigrationManager) limitDowngradeSteps(
+	db *gorm.DB,
+	plan migrationsPlan,
+	savedMigrations []models.MigrationModel,
+	steps int,
+) (migrationsPlan, Version, error) {
+	plan = plan.limitSteps(steps)
+
+	targetVersion, err := m.plannedDowngradeVersion(db, plan, savedMigrations)
+	if err != nil {
+		return migrationsPlan{}, Version{}, err
+	}
+	return plan, targetVersion, nil
+}
+
+// plannedDowngradeVersion возвращает версию, которая будет сохранена после выполнения плана отката: версию,
+// предшествующую последней откатываемой миграции, или сохраненную версию, если план пуст.
+func (m *MigrationManager) plannedDowngradeVersion(
+	db *gorm.DB,
+	plan migrationsPlan,
+	savedMigrations []models.MigrationModel,
+) (Version, error) {
+	planned := plan.Migrations()
+	if len(planned) == 0 {
+		return m.getSavedAppVersion(db)
+	}
+	return previousVersion(planned[len(planned)-1], savedMigrations), nil
+}
+
+// runDowngradePlan откатывает миграции плана по порядку. savedMigrations - сохраненные миграции в порядке убывания
+// ранга.
+func (m *MigrationManager) runDowngradePlan(
+	ctx context.Context,
+	db, stateDB *gorm.DB,
+	savedMigrations []models.MigrationModel,
+	plan migrationsPlan,
+) error {
 	for !plan.IsEmpty() {
+		err := ctx.Err()
+		if err != nil {
+			m.logger.Println("Downgrade interrupted:", err)
+			return err
+		}
+
 		migrationModel := plan.PopFirst()
 
 		migration, ok := m.findMigration(migrationModel)
 		if !ok {
-			panic(fmt.Sprintf(
-				"migration (type: %s, version: %s) not found\n",
-				migrationModel.Type, migrationModel.Version,
-			))
+			return newMigrationNotFoundError(migrationModel, PhaseDowngrade)
 		}
 
-		err = m.executeDowngrade(migrationModel, migration)
+		previousState := migrationModel.State
+		err = m.saveStateRunning(stateDB, migrationModel)
 		if err != nil {
 			return err
 		}
 
-		err = m.saveStateAfterDowngrading(savedMigrations, migrationModel, migration)
+		err = m.executeDowngrade(db, migrationModel, migration)
+		if err != nil && ctx.Err() != nil {
+			return m.saveStateOnInterruptedMigration(stateDB, migrationModel, migration, previousState, err)
+		}
+		if err != nil {
+			return m.saveStateOnFailedDowngrade(stateDB, migrationModel, migration, previousState, err)
+		}
+
+		err = m.saveStateAfterDowngrading(stateDB, savedMigrations, migrationModel, migration)
 		if err != nil {
 			return err
 		}
 	}
 
-	m.logger.Println("Downgrade completed")
-	return
+	return nil
 }
 
-func (m *MigrationManager) planDowngrade() (migrationsPlan, error) {
-	savedMigrations, err := m.saveNewMigrations()
+func (m *MigrationManager) planDowngrade(
+	db *gorm.DB,
+	savedMigrations []models.MigrationModel,
+	targetVersion Version,
+) (migrationsPlan, error) {
+	savedVersion, err := m.getSavedAppVersion(db)
 	if err != nil {
 		return migrationsPlan{}, err
 	}
 
 	planner := downgradePlanner{
 		manager:         m,
+		targetVersion:   targetVersion,
+		savedVersion:    savedVersion,
 		savedMigrations: savedMigrations,
 	}
 
 	return planner.MakePlan(), nil
 }
 
-func (m *MigrationManager) executeDowngrade(migrationModel models.MigrationModel, migration *Migration) error {
+// resolveDowngradeTargetVersion разбирает target версию отката, которая в отличие от Migrate должна быть задана явно.
+func (m *MigrationManager) resolveDowngradeTargetVersion(version string) (Version, error) {
+	if version == "" {
+		return Version{}, ErrTargetVersionRequired
+	}
+	return parseVersion(version)
+}
+
+// validateDowngradePlan проверяет план отката целиком до отката первой миграции: все миграции плана должны быть
+// зарегистрированы, реализовывать VersionedMigrator и не быть помечены как необратимые, а target версия не должна
+// быть ниже успешно выполненной миграции типа TypeBaseline, т.к. такие миграции не откатываются.
+func (m *MigrationManager) validateDowngradePlan(
+	plan migrationsPlan,
+	savedMigrations []models.MigrationModel,
+	targetVersion Version,
+) error {
+	blockers := make([]error, 0)
+
+	for _, migrationModel := range plan.Migrations() {
+		migration, ok := m.findMigration(migrationModel)
+		if !ok {
+			blockers = append(blockers, newMigrationNotFoundError(migrationModel, PhaseDowngrade))
+			continue
+		}
+
+		if _, ok = migration.migrator.(VersionedMigrator); !ok {
+			blockers = append(blockers, fmt.Errorf("%w: version %s", ErrNotVersionedMigrator, migrationModel.Version))
+		}
+		if migration.irreversible {
+			blockers = append(blockers, fmt.Errorf("%w: version %s", ErrIrreversibleMigration, migrationModel.Version))
+		}
+	}
+
+	for _, migrationModel := range savedMigrations {
+		if migrationModel.Type != string(TypeBaseline) || migrationModel.State != models.StateSuccess {
+			continue
+		}
+
+		baselineVersion := mustParseVersion(migrationModel.Version)
+		if baselineVersion.MoreThan(targetVersion) {
+			blockers = append(blockers, fmt.Errorf(
+				"%w: baseline migration %s cannot be undone",
+				ErrTargetUnreachable, migrationModel.Version,
+			))
+		}
+	}
+
+	if len(blockers) != 0 {
+		return &DowngradeBlockedError{
+			TargetVersion: targetVersion.String(),
+			Blockers:      blockers,
+		}
+	}
+	return nil
+}
+
+func (m *MigrationManager) executeDowngrade(
+	db *gorm.DB,
+	migrationModel models.MigrationModel,
+	migration *Migration,
+) error {
 	m.logger.Printf(
 		"Downgrading %s migration: version %s. State: %s\n",
 		migrationModel.Type, migrationModel.Version, migrationModel.State,
 	)
 
+	// план отката проверяется заранее, проверка здесь защищает пути, выполняющие откат без плана (см. compensate)
+	if migration.irreversible {
+		return newMigrationExecutionError(migrationModel, PhaseDowngrade, ErrIrreversibleMigration)
+	}
+
 	versionedMigrator, ok := migration.migrator.(VersionedMigrator)
 	if !ok {
-		panic("versioned migration must satisfy VersionedMigrator interface")
+		return newMigrationExecutionError(migrationModel, PhaseDowngrade, ErrNotVersionedMigrator)
+	}
+
+	err := m.runMigrator(db, migration, versionedMigrator.Downgrade)
+	if err != nil {
+		m.logger.Println("Error occurred on downgrade:", err)
+		return newMigrationExecutionError(migrationModel, PhaseDowngrade, err)
+	}
+
+	m.logger.Println("Downgrade complete")
+	return nil
+}
+
+// saveStateOnFailedDowngrade восстанавливает состояние миграции, откат которой не удался. Нетранзакционная миграция
+// помечается как models.StateFailure, т.к. могла быть откачена частично.
+func (m *MigrationManager) saveStateOnFailedDowngrade(
+	db *gorm.DB,
+	migrationModel models.MigrationModel,
+	migration *Migration,
+	previousState models.MigrationState,
+	cause error,
+) error {
+	if m.groupTransaction {
+		return cause
 	}
 
 	var err error
 	if migration.transaction {
-		err = m.db.Transaction(versionedMigrator.Downgrade)
+		err = repository.UpdateMigrationState(db, &migrationModel, previousState)
 	} else {
-		err = versionedMigrator.Downgrade(m.db)
+		m.logger.Printf(
+			"migration (type: %s, version: %s) failed to downgrade outside of transaction and could be partially undone\n",
+			migrationModel.Type, migrationModel.Version,
+		)
+		err = repository.UpdateMigrationStateFailed(db, &migrationModel, models.StateFailure, cause)
 	}
 	if err != nil {
-		m.logger.Println("Error occurred on migrate:", err)
 		return err
 	}
-
-	m.logger.Println("Downgrade complete")
-	return nil
+	return cause
 }
 
-func (m *MigrationManager) saveStateAfterDowngrading(savedMigrations []models.MigrationModel, migrationModel models.MigrationModel, migration *Migration) error {
-	err := repository.UpdateMigrationStateExecuted(m.db, &migrationModel, models.StateUndone, migration.checksum)
+func (m *MigrationManager) saveStateAfterDowngrading(
+	db *gorm.DB,
+	savedMigrations []models.MigrationModel,
+	migrationModel models.MigrationModel,
+	migration *Migration,
+) error {
+	err := repository.UpdateMigrationStateExecuted(db, &migrationModel, models.StateUndone, migration.checksum)
 	if err != nil {
 		return err
 	}
 
-	return m.saveVersionDowngrade(migrationModel, savedMigrations)
+	return m.saveVersionDowngrade(db, migrationModel, savedMigrations)
 }
 
 func (m *MigrationManager) saveVersionDowngrade(
+	db *gorm.DB,
 	migrationModel models.MigrationModel,
 	savedMigrations []models.MigrationModel,
 ) error {
-	// фильтруем миграции типа TypeRepeatable
-	filteredMigrations := make([]models.MigrationModel, 0, len(savedMigrations))
-	for i, _ := range savedMigrations {
-		if savedMigrations[i].Type == string(TypeRepeatable) {
-			continue
-		}
-		filteredMigrations = append(filteredMigrations, savedMigrations[i])
-	}
-
-	sort.SliceStable(filteredMigrations, func(i, j int) bool {
-		leftVersioned := mustParseVersion(filteredMigrations[i].Version)
-		rightVersioned := mustParseVersion(filteredMigrations[j].Version)
-
-		return leftVersioned.LessThan(rightVersioned)
-	})
+	return repository.SaveVersion(db, previousVersion(migrationModel, savedMigrations).String())
+}
 
+// previousVersion возвращает версию, которая сохраняется после отката миграции: максимальную версию успешно
+// выполненных миграций типов TypeBaseline и TypeVersioned ниже версии откатываемой миграции или нулевую версию, если
+// таких миграций нет. Миграции, которые не были применены (например, в состоянии models.StateFailedIgnored),
+// не учитываются.
+func previousVersion(migrationModel models.MigrationModel, savedMigrations []models.MigrationModel) Version {
 	undoneMigrationVersion := mustParseVersion(migrationModel.Version)
 	versionToSave := Version{Major: 0, Minor: 0, Patch: 0, PreRelease: 0}
-	// находим предыдущую версию
-	for i, _ := range filteredMigrations {
-		if filteredMigrations[i].Type != string(TypeVersioned) {
+	for i, _ := range savedMigrations {
+		if savedMigrations[i].Type == string(TypeRepeatable) || savedMigrations[i].State != models.StateSuccess {
 			continue
 		}
 
-		migrationVersion := mustParseVersion(filteredMigrations[i].Version)
-		if migrationVersion == undoneMigrationVersion {
-			if i != 0 {
-				versionToSave = mustParseVersion(filteredMigrations[i-1].Version)
-			}
-			break
+		migrationVersion := mustParseVersion(savedMigrations[i].Version)
+		if migrationVersion.LessThan(undoneMigrationVersion) && migrationVersion.MoreThan(versionToSave) {
+			versionToSave = migrationVersion
 		}
 	}
 
-	return repository.SaveVersion(m.db, versionToSave.String())
+	return versionToSave
 }
@@ -1,6 +1,7 @@
 package go_migrator
 
 import (
+	"context"
 	"fmt"
 	"github.com/MashinIvan/go-migrator/internal/models"
 	"github.com/MashinIvan/go-migrator/internal/repository"
@@ -14,40 +15,194 @@ import (
 // Все зарегистрированные миграции сохраняются в таблицу migrations. Миграции считаются новыми по инедтификатору
 // f(версия, тип миграции).
 //
-// Паникует при попытке сохранить миграцию с версией меньшей, чем уже сохраненные.
-// Паникует в случае, если какая-либо из необходимых в рамках выполнения операции миграций не была найдена.
+// Если задан Locker (см. WithLocker), вся операция, включая создание системных таблиц и построение плана,
+// выполняется под блокировкой.
+//
+// Возвращает *OutOfOrderRegistrationError при попытке сохранить миграцию с версией меньшей, чем уже сохраненные,
+// *MigrationNotFoundError, если какая-либо из необходимых в рамках выполнения операции миграций не была найдена,
+// и *MigrationExecutionError при ошибке выполнения миграции.
 func (m *MigrationManager) Migrate() error {
-	m.logger.Println("Preparing migrations execution")
+	return m.MigrateContext(context.Background())
+}
+
+// MigrateContext выполняет Migrate с учетом контекста. Контекст передается во все запросы к базе данных и в каждый
+// Migrator.Migrate через gorm.DB.WithContext.
+// При отмене контекста выполнение прекращается перед следующей миграцией. Прерванная отменой миграция считается
+// невыполненной и возвращается в прежнее состояние: транзакционная откатывается, у нетранзакционной, которая могла
+// быть применена частично, в сообщении об ошибке сохраняется причина прерывания. Миграция выполняется следующим
+// запуском Migrate.
+func (m *MigrationManager) MigrateContext(ctx context.Context) error {
+	return m.MigrateToContext(ctx, m.targetVersion)
+}
 
-	err := m.initSystemTables()
+// MigrateTo выполняет Migrate до версии version вместо target версии, заданной при создании управляющего.
+// Пустая строка означает последнюю версию зарегистрированных миграций.
+func (m *MigrationManager) MigrateTo(version string) error {
+	return m.MigrateToContext(context.Background(), version)
+}
+
+// MigrateToContext выполняет MigrateTo с учетом контекста (см. MigrateContext).
+func (m *MigrationManager) MigrateToContext(ctx context.Context, version string) error {
+	targetVersion, err := m.resolveTargetVersion(version)
 	if err != nil {
 		return err
 	}
 
-	savedMigrations, err := m.saveNewMigrations()
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.migrate(ctx, targetVersion, 0)
+	})
+}
+
+// MigrateLatest выполняет Migrate до максимальной версии зарегистрированных миграций.
+func (m *MigrationManager) MigrateLatest() error {
+	return m.MigrateToContext(context.Background(), "")
+}
+
+// MigrateLatestContext выполняет MigrateLatest с учетом контекста (см. MigrateContext).
+func (m *MigrationManager) MigrateLatestContext(ctx context.Context) error {
+	return m.MigrateToContext(ctx, "")
+}
+
+// MigrateSteps выполняет не более steps миграций типов TypeBaseline и TypeVersioned из плана Migrate в порядке их
+// выполнения, после чего версия в таблице version соответствует последней выполненной миграции. Миграции типа
+// TypeRepeatable выполняются, только если в план попали все остальные миграции.
+// Возвращает ErrInvalidStepCount, если steps не положительно.
+func (m *MigrationManager) MigrateSteps(steps int) error {
+	return m.MigrateStepsContext(context.Background(), steps)
+}
+
+// MigrateStepsContext выполняет MigrateSteps с учетом контекста (см. MigrateContext).
+func (m *MigrationManager) MigrateStepsContext(ctx context.Context, steps int) error {
+	if steps <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidStepCount, steps)
+	}
+
+	targetVersion, err := m.resolveTargetVersion(m.targetVersion)
 	if err != nil {
 		return err
 	}
 
-	plan := m.planMigrate(savedMigrations)
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.migrate(ctx, targetVersion, steps)
+	})
+}
+
+// migrate выполняет план Migrate до версии targetVersion. steps ограничивает количество выполняемых миграций, ноль
+// означает выполнение плана целиком.
+func (m *MigrationManager) migrate(ctx context.Context, targetVersion Version, steps int) error {
+	m.logger.Println("Preparing migrations execution, target version:", targetVersion)
+	if steps != 0 {
+		m.logger.Println("Step limit:", steps)
+	}
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkGroupTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := m.primaryDB(ctx)
+
+	err = m.initSystemTables(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.recoverInterruptedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if m.groupTransaction {
+		m.logger.Println("Executing migrations inside group transaction")
+	}
+	err = m.execute(ctx, db, func(db, stateDB *gorm.DB) error {
+		return m.executeMigratePlan(ctx, db, stateDB, targetVersion, steps)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Println("Migrations completed, current repository version is up to date")
+	return nil
+}
+
+// executeMigratePlan сохраняет новые миграции, строит план и выполняет его. Состояния миграций после выполнения
+// сохраняются через stateDB. Если steps не равно нулю, план ограничивается steps миграциями.
+func (m *MigrationManager) executeMigratePlan(
+	ctx context.Context,
+	db, stateDB *gorm.DB,
+	targetVersion Version,
+	steps int,
+) error {
+	savedMigrations, err := m.saveNewMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	versionBefore, err := m.getSavedAppVersion(db)
+	if err != nil {
+		return err
+	}
+
+	plan, err := m.planMigrate(db, savedMigrations, targetVersion)
+	if err != nil {
+		return err
+	}
+	if steps != 0 {
+		plan = plan.limitSteps(steps)
+	}
+
+	return m.runMigratePlan(ctx, db, stateDB, savedMigrations, versionBefore, plan)
+}
+
+// runMigratePlan выполняет миграции плана по порядку. savedMigrations - все сохраненные миграции, versionBefore -
+// версия до начала выполнения, к которой откатываются миграции при WithRollbackOnFailure.
+// Каждый вызов получает новый идентификатор запуска, который сохраняется у успешно выполненных миграций
+// (см. RollbackRun).
+func (m *MigrationManager) runMigratePlan(
+	ctx context.Context,
+	db, stateDB *gorm.DB,
+	savedMigrations []models.MigrationModel,
+	versionBefore Version,
+	plan migrationsPlan,
+) error {
+	applied := make([]appliedMigration, 0)
+
+	runID := newRunID()
+	if !plan.IsEmpty() {
+		m.logger.Println("Run id:", runID)
+	}
 
 	for !plan.IsEmpty() {
+		err := ctx.Err()
+		if err != nil {
+			m.logger.Println("Migrations interrupted:", err)
+			return err
+		}
+
 		migrationModel := plan.PopFirst()
 
 		migration, ok := m.findMigration(migrationModel)
 		if !ok {
 			if !m.allowBypassNotFound(migrationModel) {
-				panic(fmt.Sprintf(
-					"migration (type: %s, version: %s) not found\n",
-					migrationModel.Type, migrationModel.Version,
-				))
+				return newMigrationNotFoundError(migrationModel, PhaseMigrate)
 			}
 
 			m.logger.Printf(
 				"migration (type: %s, version: %s) not found, skipping",
 				migrationModel.Type, migrationModel.Version,
 			)
-			err = repository.UpdateMigrationState(m.db, &migrationModel, models.StateNotFound)
+			err = repository.UpdateMigrationState(db, &migrationModel, models.StateNotFound)
 			if err != nil {
 				return err
 			}
@@ -55,41 +210,91 @@ func (m *MigrationManager) Migrate() error {
 			continue
 		}
 
-		err = m.executeMigration(migrationModel, migration)
-		if err != nil && !migration.allowFailure {
-			err = repository.UpdateMigrationState(m.db, &migrationModel, models.StateFailure)
+		previousState := migrationModel.State
+		err = m.saveStateRunning(stateDB, migrationModel)
+		if err != nil {
+			return err
+		}
+
+		var attempts int
+		attempts, err = m.executeMigration(db, migrationModel, migration)
+		if !m.groupTransaction {
+			updateErr := repository.UpdateMigrationAttempts(stateDB, &migrationModel, attempts)
+			if updateErr != nil {
+				return updateErr
+			}
+		}
+		if err != nil && ctx.Err() != nil {
+			return m.saveStateOnInterruptedMigration(stateDB, migrationModel, migration, previousState, err)
+		}
+		if err != nil && migration.allowFailure {
+			m.logger.Printf(
+				"migration (type: %s, version: %s) failed, failure is allowed, continuing\n",
+				migrationModel.Type, migrationModel.Version,
+			)
+			err = repository.UpdateMigrationStateFailed(stateDB, &migrationModel, models.StateFailedIgnored, err)
 			if err != nil {
 				return err
 			}
 
+			continue
+		}
+		if err != nil {
+			// групповая транзакция будет откачена целиком, сохранять состояние не нужно
+			if m.groupTransaction {
+				return err
+			}
+
+			updateErr := repository.UpdateMigrationStateFailed(stateDB, &migrationModel, models.StateFailure, err)
+			if updateErr != nil {
+				return updateErr
+			}
+
+			if m.rollbackOnFailure {
+				return m.compensate(db, stateDB, applied, versionBefore, err)
+			}
 			return err
 		}
 
-		err = m.saveStateOnSuccessfulMigration(savedMigrations, migrationModel, migration)
+		err = m.saveStateOnSuccessfulMigration(stateDB, savedMigrations, migrationModel, migration, runID)
 		if err != nil {
 			return err
 		}
+
+		if migration.migrationType != TypeRepeatable {
+			applied = append(applied, appliedMigration{model: migrationModel, migration: migration})
+		}
 	}
 
-	m.logger.Println("Migrations completed, current repository version is up to date")
 	return nil
 }
 
-func (m *MigrationManager) planMigrate(savedMigrations []models.MigrationModel) migrationsPlan {
+func (m *MigrationManager) planMigrate(
+	db *gorm.DB,
+	savedMigrations []models.MigrationModel,
+	targetVersion Version,
+) (migrationsPlan, error) {
+	savedVersion, err := m.getSavedAppVersion(db)
+	if err != nil {
+		return migrationsPlan{}, err
+	}
+
 	planner := migratePlanner{
 		manager:         m,
+		targetVersion:   targetVersion,
+		savedVersion:    savedVersion,
 		savedMigrations: savedMigrations,
 	}
-	return planner.MakePlan()
+	return planner.MakePlan(), nil
 }
 
-func (m *MigrationManager) initSystemTables() error {
-	hasVersionTable := repository.HasVersionTable(m.db)
-	hasMigrationsTable := repository.HasMigrationsTable(m.db)
+func (m *MigrationManager) initSystemTables(db *gorm.DB) error {
+	hasVersionTable := repository.HasVersionTable(db)
+	hasMigrationsTable := repository.HasMigrationsTable(db)
 
 	if !hasVersionTable {
 		m.logger.Println("Table versions not found, creating")
-		err := repository.CreateVersionTable(m.db)
+		err := repository.CreateVersionTable(db)
 		if err != nil {
 			return err
 		}
@@ -97,21 +302,49 @@ func (m *MigrationManager) initSystemTables() error {
 
 	if !hasMigrationsTable {
 		m.logger.Println("Table migrations not found, creating")
-		err := repository.CreateMigrationsTable(m.db)
+		err := repository.CreateMigrationsTable(db)
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return repository.UpgradeMigrationsTable(db)
 }
 
-func (m *MigrationManager) saveNewMigrations() ([]models.MigrationModel, error) {
-	savedMigrations, err := repository.GetMigrationsSorted(m.db, repository.OrderASC)
+func (m *MigrationManager) saveNewMigrations(db *gorm.DB) ([]models.MigrationModel, error) {
+	savedMigrations, err := m.loadSavedMigrations(db, repository.OrderASC)
+	if err != nil {
+		return nil, err
+	}
+
+	requests, err := m.newMigrationRequests(savedMigrations)
 	if err != nil {
 		return nil, err
 	}
 
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, request := range requests {
+			migration, err := repository.SaveMigration(tx, request)
+			if err != nil {
+				return err
+			}
+
+			savedMigrations = append(savedMigrations, migration)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return savedMigrations, nil
+}
+
+// newMigrationRequests возвращает запросы на сохранение зарегистрированных миграций, которых нет среди сохраненных,
+// в порядке возрастания версий.
+func (m *MigrationManager) newMigrationRequests(
+	savedMigrations []models.MigrationModel,
+) ([]repository.SaveMigrationRequest, error) {
 	maxRank := 0
 	for i, _ := range savedMigrations {
 		if rank := savedMigrations[i].Rank; rank > maxRank {
@@ -119,98 +352,148 @@ func (m *MigrationManager) saveNewMigrations() ([]models.MigrationModel, error)
 		}
 	}
 
-	newMigrations := make([]*Migration, 0, len(m.registeredMigrations))
-	for i, _ := range m.registeredMigrations {
-		if migrationIsNew(m.registeredMigrations[i], savedMigrations) {
-			newMigrations = append(newMigrations, m.registeredMigrations[i])
-		}
-	}
+	newMigrations := m.newMigrations(savedMigrations)
 
 	// запрет на сохранение миграций с версией, которая ниже максимальной версии из уже загерисрированных миграций
 	for i, _ := range newMigrations {
-		versionIncorrect := false
+		versionToSave := mustParseVersion(newMigrations[i].version)
+
 		for j, _ := range savedMigrations {
 			versionSaved := mustParseVersion(savedMigrations[j].Version)
-			versionToSave := mustParseVersion(newMigrations[i].version)
-
 			if versionSaved.MoreThan(versionToSave) {
-				versionIncorrect = true
+				return nil, &OutOfOrderRegistrationError{
+					Type:         newMigrations[i].migrationType,
+					Version:      newMigrations[i].version,
+					Identifier:   newMigrations[i].identifier,
+					SavedVersion: savedMigrations[j].Version,
+				}
 			}
 		}
-		if versionIncorrect {
-			panic(fmt.Sprintf(
-				"Attempting to register migration with lower version than existing one. Type: %s. Identifier: %d",
-				newMigrations[i].migrationType, newMigrations[i].identifier,
-			))
+	}
+
+	requests := make([]repository.SaveMigrationRequest, 0, len(newMigrations))
+	for i, _ := range newMigrations {
+		requests = append(requests, repository.SaveMigrationRequest{
+			Rank:        maxRank + (i + 1),
+			Type:        string(newMigrations[i].migrationType),
+			Version:     newMigrations[i].version,
+			Description: newMigrations[i].migrator.Description(),
+			State:       models.StateRegistered,
+		})
+	}
+	return requests, nil
+}
+
+// newMigrations возвращает зарегистрированные миграции, которых нет среди сохраненных, в порядке возрастания версий.
+func (m *MigrationManager) newMigrations(savedMigrations []models.MigrationModel) []*Migration {
+	newMigrations := make([]*Migration, 0, len(m.registeredMigrations))
+	for i, _ := range m.registeredMigrations {
+		if migrationIsNew(m.registeredMigrations[i], savedMigrations) {
+			newMigrations = append(newMigrations, m.registeredMigrations[i])
 		}
 	}
 
 	sort.SliceStable(newMigrations, func(i, j int) bool {
-		leftVersioned := m.registeredMigrations[i].migrator.Version()
-		rightVersioned := m.registeredMigrations[j].migrator.Version()
+		leftVersioned := mustParseVersion(newMigrations[i].version)
+		rightVersioned := mustParseVersion(newMigrations[j].version)
 
 		return leftVersioned.LessThan(rightVersioned)
 	})
+	return newMigrations
+}
 
-	err = m.db.Transaction(func(tx *gorm.DB) error {
-		for i, _ := range newMigrations {
-			migration, err := repository.SaveMigration(tx, repository.SaveMigrationRequest{
-				Rank:        maxRank + (i + 1),
-				Type:        string(newMigrations[i].migrationType),
-				Version:     newMigrations[i].version,
-				Description: newMigrations[i].migrator.Description(),
-				State:       models.StateRegistered,
-			})
-			if err != nil {
-				return err
-			}
+func (m *MigrationManager) executeMigration(
+	db *gorm.DB,
+	migrationModel models.MigrationModel,
+	migration *Migration,
+) (int, error) {
+	m.logger.Printf(
+		"Executing %s migration: version %s. State: %s\n",
+		migrationModel.Type, migrationModel.Version, migrationModel.State,
+	)
 
-			savedMigrations = append(savedMigrations, migration)
+	attempts, err := m.withRetries(db.Statement.Context, migration, func() error {
+		if m.groupTransaction {
+			return m.executeMigrationInSavepoint(db, migrationModel, migration)
 		}
-		return nil
+		return m.runMigrator(db, migration, migration.migrator.Migrate)
 	})
 	if err != nil {
-		return nil, err
+		m.logger.Println("Error occurred on migrate:", err)
+		return attempts, newMigrationExecutionError(migrationModel, PhaseMigrate, err)
 	}
 
-	return savedMigrations, nil
+	m.logger.Println("Migration Complete")
+	return attempts, nil
 }
 
-func (m *MigrationManager) executeMigration(migrationModel models.MigrationModel, migration *Migration) error {
-	m.logger.Printf(
-		"Executing %s migration: version %s. State: %s\n",
-		migrationModel.Type, migrationModel.Version, migrationModel.State,
-	)
+// executeMigrationInSavepoint выполняет миграцию внутри групповой транзакции под отдельной точкой сохранения.
+// Миграция, которой разрешено завершаться с ошибкой, откатывается до своей точки сохранения, не прерывая группу.
+func (m *MigrationManager) executeMigrationInSavepoint(
+	db *gorm.DB,
+	migrationModel models.MigrationModel,
+	migration *Migration,
+) error {
+	savepoint := fmt.Sprintf("migration_%d", migrationModel.Id)
 
-	var err error
-	if migration.transaction {
-		err = m.db.Transaction(migration.migrator.Migrate)
-	} else {
-		err = migration.migrator.Migrate(m.db)
+	err := db.SavePoint(savepoint).Error
+	if err != nil {
+		return err
+	}
+	m.logger.Println("Savepoint created:", savepoint)
+
+	migrateErr := m.runMigrator(db, migration, migration.migrator.Migrate)
+	if migrateErr != nil {
+		if !migration.allowFailure {
+			return migrateErr
+		}
+
+		err = db.RollbackTo(savepoint).Error
+		if err != nil {
+			return err
+		}
+		m.logger.Println("Rolled back to savepoint:", savepoint)
+
+		err = repository.UpdateMigrationSavepoint(db, &migrationModel, savepoint, models.SavepointRolledBack)
+		if err != nil {
+			return err
+		}
+		return migrateErr
 	}
+
+	err = db.Exec("RELEASE SAVEPOINT " + savepoint).Error
 	if err != nil {
-		m.logger.Println("Error occurred on migrate:", err)
 		return err
 	}
+	m.logger.Println("Savepoint released:", savepoint)
 
-	m.logger.Println("Migration Complete")
-	return nil
+	return repository.UpdateMigrationSavepoint(db, &migrationModel, savepoint, models.SavepointReleased)
 }
 
 func (m *MigrationManager) saveStateOnSuccessfulMigration(
+	db *gorm.DB,
 	savedMigrations []models.MigrationModel,
 	migrationModel models.MigrationModel,
 	migration *Migration,
+	runID string,
 ) error {
 	switch migration.migrationType {
 	case TypeVersioned:
-		err := repository.SaveVersion(m.db, migration.version)
+		savedVersion, err := m.getSavedAppVersion(db)
 		if err != nil {
 			return err
 		}
 
+		// повторно выполненная откаченная миграция (см. WithReapplyUndone) не понижает сохраненную версию
+		if mustParseVersion(migration.version).MoreThan(savedVersion) {
+			err = repository.SaveVersion(db, migration.version)
+			if err != nil {
+				return err
+			}
+		}
+
 	case TypeBaseline:
-		err := repository.SaveVersion(m.db, migration.version)
+		err := repository.SaveVersion(db, migration.version)
 		if err != nil {
 			return err
 		}
@@ -221,19 +504,68 @@ func (m *MigrationManager) saveStateOnSuccessfulMigration(
 				break
 			}
 
-			err = repository.UpdateMigrationState(m.db, &savedMigrations[i], models.StateSkipped)
+			err = repository.UpdateMigrationState(db, &savedMigrations[i], models.StateSkipped)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	err := repository.UpdateMigrationStateExecuted(m.db, &migrationModel, models.StateSuccess, migration.checksum)
+	return repository.UpdateMigrationStateExecutedInRun(
+		db, &migrationModel, models.StateSuccess, migration.checksum, runID,
+	)
+}
+
+// saveStateRunning помечает миграцию выполняемой до начала ее выполнения, чтобы после аварийного завершения процесса
+// прерванная миграция была обнаружена при следующем запуске. Внутри групповой транзакции отметка не сохраняется, т.к.
+// не будет видна до фиксации транзакции.
+func (m *MigrationManager) saveStateRunning(db *gorm.DB, migrationModel models.MigrationModel) error {
+	if m.groupTransaction {
+		return nil
+	}
+	return repository.UpdateMigrationStateRunning(db, &migrationModel, m.owner)
+}
+
+// saveStateOnInterruptedMigration сохраняет состояние миграции, выполнение или откат которой прерван отменой
+// контекста: миграции возвращается прежнее состояние, для нетранзакционной миграции также сохраняется причина
+// прерывания (см. MigrateContext).
+func (m *MigrationManager) saveStateOnInterruptedMigration(
+	db *gorm.DB,
+	migrationModel models.MigrationModel,
+	migration *Migration,
+	previousState models.MigrationState,
+	cause error,
+) error {
+	// групповая транзакция будет откачена целиком вместе с состояниями миграций
+	if m.groupTransaction {
+		m.logger.Printf(
+			"migration (type: %s, version: %s) interrupted, group transaction rolled back\n",
+			migrationModel.Type, migrationModel.Version,
+		)
+		return cause
+	}
+
+	if migration.transaction {
+		m.logger.Printf(
+			"migration (type: %s, version: %s) interrupted, transaction rolled back\n",
+			migrationModel.Type, migrationModel.Version,
+		)
+		err := repository.UpdateMigrationState(db, &migrationModel, previousState)
+		if err != nil {
+			return err
+		}
+		return cause
+	}
+
+	m.logger.Printf(
+		"migration (type: %s, version: %s) interrupted outside of transaction and could be partially executed\n",
+		migrationModel.Type, migrationModel.Version,
+	)
+	err := repository.UpdateMigrationStateInterrupted(db, &migrationModel, previousState, cause)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return cause
 }
 
 func (m *MigrationManager) allowBypassNotFound(migrationModel models.MigrationModel) bool {
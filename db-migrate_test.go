@@ -0,0 +1,74 @@
+package go_migrator
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"gorm.io/gorm"
+	"testing"
+)
+
+type testMigrator struct {
+	version Version
+}
+
+func (t testMigrator) Migrate(*gorm.DB) error   { return nil }
+func (t testMigrator) Downgrade(*gorm.DB) error { return nil }
+func (t testMigrator) Description() string      { return "test migration " + t.version.String() }
+func (t testMigrator) Version() Version         { return t.version }
+
+func TestNewMigrationRequestsOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		registered []Version
+		saved      []models.MigrationModel
+		requests   []string
+	}{
+		{
+			name:       "registered in version order",
+			registered: []Version{{Major: 1}, {Major: 1, Minor: 1}, {Major: 1, Minor: 2}},
+			saved:      []models.MigrationModel{newTestModel(TypeVersioned, "1.0.0.0", models.StateSuccess)},
+			requests:   []string{"1.1.0.0", "1.2.0.0"},
+		},
+		{
+			// индексы новых миграций не совпадают с индексами зарегистрированных, поэтому сортировка по
+			// зарегистрированным миграциям сохраняла бы 1.2.0.0 раньше 1.1.0.0
+			name:       "registered out of version order",
+			registered: []Version{{Major: 1}, {Major: 1, Minor: 2}, {Major: 1, Minor: 1}},
+			saved:      []models.MigrationModel{newTestModel(TypeVersioned, "1.0.0.0", models.StateSuccess)},
+			requests:   []string{"1.1.0.0", "1.2.0.0"},
+		},
+		{
+			name:       "nothing saved",
+			registered: []Version{{Major: 1, Minor: 3}, {Major: 1, Minor: 1}, {Major: 1, Minor: 2}},
+			requests:   []string{"1.1.0.0", "1.2.0.0", "1.3.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager()
+			m.connections = make(map[string]*gorm.DB)
+			for _, version := range tt.registered {
+				err := m.RegisterMigration(NewVersionedMigration(testMigrator{version: version}))
+				if err != nil {
+					t.Fatalf("register migration %s: %v", version, err)
+				}
+			}
+
+			requests, err := m.newMigrationRequests(tt.saved)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(requests) != len(tt.requests) {
+				t.Fatalf("expected %d requests, got %d", len(tt.requests), len(requests))
+			}
+			for i, _ := range requests {
+				if requests[i].Version != tt.requests[i] {
+					t.Fatalf("expected version %s at %d, got %s", tt.requests[i], i, requests[i].Version)
+				}
+				if requests[i].Rank != i+1 {
+					t.Fatalf("expected rank %d for version %s, got %d", i+1, requests[i].Version, requests[i].Rank)
+				}
+			}
+		})
+	}
+}
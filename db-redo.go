@@ -0,0 +1,129 @@
+package go_migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrRedoInProduction = errors.New("redo is not allowed in production environment")
+)
+
+// Environment - окружение, в котором работает управляющий миграциями (см. WithEnvironment).
+type Environment string
+
+const (
+	// EnvironmentDevelopment - окружение разработки, используется по умолчанию.
+	EnvironmentDevelopment Environment = "development"
+	// EnvironmentProduction - рабочее окружение, в котором запрещены операции разработки, например Redo.
+	EnvironmentProduction Environment = "production"
+)
+
+// Redo откатывает последнюю выполненную миграцию типа TypeVersioned и выполняет ее повторно с текущим кодом.
+// Предназначена для разработки новой миграции, см. RedoSteps.
+func (m *MigrationManager) Redo() error {
+	return m.RedoStepsContext(context.Background(), 1)
+}
+
+// RedoSteps откатывает steps последних выполненных миграций типа TypeVersioned тем же путем, что и DowngradeSteps,
+// возвращает им состояние models.StateRegistered и выполняет их повторно в порядке возрастания версий. Контрольные
+// суммы миграций сохраняются заново. Откат и повторное выполнение проводятся под одной блокировкой (см. WithLocker).
+//
+// Возвращает ErrRedoInProduction, если управляющий создан с WithEnvironment(EnvironmentProduction),
+// ErrInvalidStepCount, если steps не положительно, а также ошибки Downgrade и Migrate.
+func (m *MigrationManager) RedoSteps(steps int) error {
+	return m.RedoStepsContext(context.Background(), steps)
+}
+
+// RedoStepsContext выполняет RedoSteps с учетом контекста (см. MigrateContext).
+func (m *MigrationManager) RedoStepsContext(ctx context.Context, steps int) error {
+	if m.environment == EnvironmentProduction {
+		return ErrRedoInProduction
+	}
+	if steps <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidStepCount, steps)
+	}
+
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.redo(ctx, steps)
+	})
+}
+
+func (m *MigrationManager) redo(ctx context.Context, steps int) error {
+	m.logger.Println("Preparing redo execution, step limit:", steps)
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkGroupTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := m.primaryDB(ctx)
+
+	if !repository.HasVersionTable(db) || !repository.HasMigrationsTable(db) {
+		return ErrNoSystemTables
+	}
+
+	err = repository.UpgradeMigrationsTable(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.recoverInterruptedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.execute(ctx, db, func(db, stateDB *gorm.DB) error {
+		return m.executeRedo(ctx, db, stateDB, steps)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Println("Redo completed")
+	return nil
+}
+
+func (m *MigrationManager) executeRedo(ctx context.Context, db, stateDB *gorm.DB, steps int) error {
+	savedMigrations, downgradePlan, versionAfterDowngrade, err := m.prepareDowngradePlan(db, Version{}, steps)
+	if err != nil {
+		return err
+	}
+
+	redone := downgradePlan.Migrations()
+	if len(redone) == 0 {
+		m.logger.Println("No applied versioned migrations to redo")
+		return nil
+	}
+
+	err = m.runDowngradePlan(ctx, db, stateDB, savedMigrations, downgradePlan)
+	if err != nil {
+		return err
+	}
+
+	// миграции откатывались в порядке убывания версий, выполняются в обратном
+	migratePlan := newMigrationsPlan()
+	for i := len(redone) - 1; i >= 0; i-- {
+		err = repository.UpdateMigrationState(stateDB, &redone[i], models.StateRegistered)
+		if err != nil {
+			return err
+		}
+		migratePlan.migrationsToRun.PushBack(redone[i])
+	}
+
+	return m.runMigratePlan(ctx, db, stateDB, savedMigrations, versionAfterDowngrade, migratePlan)
+}
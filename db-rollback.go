@@ -0,0 +1,275 @@
+package go_migrator
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRunNotFound        = errors.New("migrations run not found")
+	ErrRollbackOutOfOrder = errors.New("migrations with higher versions are applied outside of rollback")
+)
+
+// rollbackFilter отбирает из плана отката до нулевой версии миграции, которые откатывает Rollback.
+type rollbackFilter struct {
+	description string
+	reason      DecisionReason
+	match       func(migrationModel models.MigrationModel) bool
+}
+
+// RollbackRun откатывает миграции типа TypeVersioned, успешно выполненные в запуске runID, в порядке отката
+// Downgrade. Идентификатор запуска сохраняется у каждой миграции, выполненной Migrate, ApplyPlan или Redo, и выводится
+// в лог в начале запуска. Миграции типов TypeBaseline и TypeRepeatable не откатываются.
+// Если после миграций запуска выполнены миграции с большими версиями из других запусков, возвращается
+// ErrRollbackOutOfOrder, т.к. сохраненная версия после отката не соответствовала бы выполненным миграциям.
+// Если задан Locker (см. WithLocker), операция выполняется под блокировкой.
+//
+// Возвращает ErrRunNotFound, если ни одна миграция не выполнена в запуске runID, а также ошибки Downgrade.
+func (m *MigrationManager) RollbackRun(runID string) error {
+	return m.RollbackRunContext(context.Background(), runID)
+}
+
+// RollbackRunContext выполняет RollbackRun с учетом контекста (см. DowngradeContext).
+func (m *MigrationManager) RollbackRunContext(ctx context.Context, runID string) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.rollback(ctx, func(savedMigrations []models.MigrationModel) (rollbackFilter, error) {
+			for _, migrationModel := range savedMigrations {
+				if runID != "" && migrationModel.RunId == runID {
+					return runRollbackFilter(runID), nil
+				}
+			}
+			return rollbackFilter{}, fmt.Errorf("%w: %q", ErrRunNotFound, runID)
+		})
+	})
+}
+
+// RollbackLastRun выполняет RollbackRun для последнего запуска, миграции которого не откачены: запуска миграции
+// типа TypeVersioned с наибольшим временем выполнения.
+func (m *MigrationManager) RollbackLastRun() error {
+	return m.RollbackLastRunContext(context.Background())
+}
+
+// RollbackLastRunContext выполняет RollbackLastRun с учетом контекста (см. DowngradeContext).
+func (m *MigrationManager) RollbackLastRunContext(ctx context.Context) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.rollback(ctx, func(savedMigrations []models.MigrationModel) (rollbackFilter, error) {
+			runID, ok := lastRunID(savedMigrations)
+			if !ok {
+				return rollbackFilter{}, fmt.Errorf("%w: no applied versioned migrations with run id", ErrRunNotFound)
+			}
+			return runRollbackFilter(runID), nil
+		})
+	})
+}
+
+// RollbackTo откатывает миграции типа TypeVersioned, успешно выполненные позже момента t, в порядке отката
+// Downgrade. Ограничения те же, что у RollbackRun.
+func (m *MigrationManager) RollbackTo(t time.Time) error {
+	return m.RollbackToContext(context.Background(), t)
+}
+
+// RollbackToContext выполняет RollbackTo с учетом контекста (см. DowngradeContext).
+func (m *MigrationManager) RollbackToContext(ctx context.Context, t time.Time) error {
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.rollback(ctx, func([]models.MigrationModel) (rollbackFilter, error) {
+			return rollbackFilter{
+				description: "executed after " + t.UTC().Format(time.RFC3339Nano),
+				reason:      ReasonExecutedBefore,
+				match: func(migrationModel models.MigrationModel) bool {
+					return migrationModel.ExecutedOn != nil && migrationModel.ExecutedOn.After(t)
+				},
+			}, nil
+		})
+	})
+}
+
+func runRollbackFilter(runID string) rollbackFilter {
+	return rollbackFilter{
+		description: "run " + runID,
+		reason:      ReasonNotInRun,
+		match: func(migrationModel models.MigrationModel) bool {
+			return migrationModel.RunId == runID
+		},
+	}
+}
+
+// lastRunID возвращает идентификатор запуска успешно выполненной миграции типа TypeVersioned с наибольшим временем
+// выполнения.
+func lastRunID(savedMigrations []models.MigrationModel) (string, bool) {
+	var last *models.MigrationModel
+	for i, _ := range savedMigrations {
+		migrationModel := &savedMigrations[i]
+		if migrationModel.Type != string(TypeVersioned) || migrationModel.State != models.StateSuccess {
+			continue
+		}
+		if migrationModel.RunId == "" || migrationModel.ExecutedOn == nil {
+			continue
+		}
+
+		if last == nil || migrationModel.ExecutedOn.After(*last.ExecutedOn) {
+			last = migrationModel
+		}
+	}
+
+	if last == nil {
+		return "", false
+	}
+	return last.RunId, true
+}
+
+// rollback откатывает миграции, отобранные фильтром, который resolve строит по сохраненным миграциям.
+func (m *MigrationManager) rollback(
+	ctx context.Context,
+	resolve func(savedMigrations []models.MigrationModel) (rollbackFilter, error),
+) error {
+	m.logger.Println("Preparing rollback execution")
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkGroupTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := m.primaryDB(ctx)
+
+	if !repository.HasVersionTable(db) || !repository.HasMigrationsTable(db) {
+		return ErrNoSystemTables
+	}
+
+	err = repository.UpgradeMigrationsTable(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.recoverInterruptedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if m.groupTransaction {
+		m.logger.Println("Executing rollback inside group transaction")
+	}
+	err = m.execute(ctx, db, func(db, stateDB *gorm.DB) error {
+		return m.executeRollbackPlan(ctx, db, stateDB, resolve)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Println("Rollback completed")
+	return nil
+}
+
+// executeRollbackPlan строит план отката до нулевой версии, оставляет в нем успешно выполненные миграции, отобранные
+// фильтром, и выполняет его.
+func (m *MigrationManager) executeRollbackPlan(
+	ctx context.Context,
+	db, stateDB *gorm.DB,
+	resolve func(savedMigrations []models.MigrationModel) (rollbackFilter, error),
+) error {
+	savedMigrations, err := m.loadSavedMigrations(db, repository.OrderDESC)
+	if err != nil {
+		return err
+	}
+
+	filter, err := resolve(savedMigrations)
+	if err != nil {
+		return err
+	}
+	m.logger.Println("Rolling back migrations", filter.description)
+
+	migrationsToPlan, err := m.saveNewMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	plan, err := m.planDowngrade(db, migrationsToPlan, Version{})
+	if err != nil {
+		return err
+	}
+	plan = plan.filter(func(migrationModel models.MigrationModel) bool {
+		return migrationModel.State == models.StateSuccess && filter.match(migrationModel)
+	}, filter.reason, filter.description)
+
+	if plan.IsEmpty() {
+		m.logger.Println("No applied versioned migrations to roll back")
+		return nil
+	}
+
+	err = checkLaterMigrations(plan, savedMigrations)
+	if err != nil {
+		return err
+	}
+
+	targetVersion, err := m.plannedDowngradeVersion(db, plan, savedMigrations)
+	if err != nil {
+		return err
+	}
+
+	err = m.validateDowngradePlan(plan, savedMigrations, targetVersion)
+	if err != nil {
+		return err
+	}
+
+	return m.runDowngradePlan(ctx, db, stateDB, savedMigrations, plan)
+}
+
+// checkLaterMigrations проверяет, что выше наименьшей версии плана отката нет успешно выполненных миграций типа
+// TypeVersioned, не попавших в план.
+func checkLaterMigrations(plan migrationsPlan, savedMigrations []models.MigrationModel) error {
+	planned := plan.Migrations()
+	lowestVersion := mustParseVersion(planned[len(planned)-1].Version)
+
+	plannedSet := make(map[uint32]struct{}, len(planned))
+	for _, migrationModel := range planned {
+		plannedSet[migrationModel.Id] = struct{}{}
+	}
+
+	laterApplied := make([]string, 0)
+	for _, migrationModel := range savedMigrations {
+		if migrationModel.Type != string(TypeVersioned) || migrationModel.State != models.StateSuccess {
+			continue
+		}
+		if _, ok := plannedSet[migrationModel.Id]; ok {
+			continue
+		}
+
+		if mustParseVersion(migrationModel.Version).MoreThan(lowestVersion) {
+			laterApplied = append(laterApplied, migrationModel.Version)
+		}
+	}
+
+	if len(laterApplied) != 0 {
+		return fmt.Errorf(
+			"%w: version %s, later versions [%s]",
+			ErrRollbackOutOfOrder, lowestVersion, strings.Join(laterApplied, ", "),
+		)
+	}
+	return nil
+}
+
+// newRunID возвращает идентификатор запуска, упорядоченный по времени начала запуска.
+func newRunID() string {
+	suffix := make([]byte, 4)
+	// crypto/rand.Read завершается ошибкой только на неисправной системе, тогда суффикс остается нулевым
+	_, _ = rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102T150405.000000Z"), hex.EncodeToString(suffix))
+}
@@ -0,0 +1,121 @@
+package go_migrator
+
+import (
+	"errors"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"testing"
+	"time"
+)
+
+func newTestRunModel(
+	version string,
+	state models.MigrationState,
+	runID string,
+	executedOn *time.Time,
+) models.MigrationModel {
+	migrationModel := newTestModel(TypeVersioned, version, state)
+	migrationModel.RunId = runID
+	migrationModel.ExecutedOn = executedOn
+	return migrationModel
+}
+
+func TestLastRunID(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) *time.Time {
+		executedOn := base.Add(time.Duration(minutes) * time.Minute)
+		return &executedOn
+	}
+
+	tests := []struct {
+		name            string
+		savedMigrations []models.MigrationModel
+		runID           string
+		ok              bool
+	}{
+		{
+			name: "no migrations",
+		},
+		{
+			name: "latest successful run",
+			savedMigrations: []models.MigrationModel{
+				newTestRunModel("1.1.0.0", models.StateSuccess, "run-1", at(0)),
+				newTestRunModel("1.2.0.0", models.StateSuccess, "run-2", at(10)),
+				newTestRunModel("1.3.0.0", models.StateSuccess, "run-1", at(5)),
+			},
+			runID: "run-2",
+			ok:    true,
+		},
+		{
+			name: "undone and failed migrations are ignored",
+			savedMigrations: []models.MigrationModel{
+				newTestRunModel("1.1.0.0", models.StateSuccess, "run-1", at(0)),
+				newTestRunModel("1.2.0.0", models.StateUndone, "run-2", at(10)),
+				newTestRunModel("1.3.0.0", models.StateFailure, "run-3", at(20)),
+			},
+			runID: "run-1",
+			ok:    true,
+		},
+		{
+			name: "migrations without run id are ignored",
+			savedMigrations: []models.MigrationModel{
+				newTestRunModel("1.1.0.0", models.StateSuccess, "", at(10)),
+				newTestRunModel("1.2.0.0", models.StateSuccess, "run-1", nil),
+			},
+		},
+		{
+			name: "only versioned migrations are considered",
+			savedMigrations: []models.MigrationModel{
+				{Type: string(TypeBaseline), Version: "1.0.0.0", State: models.StateSuccess, RunId: "run-2",
+					ExecutedOn: at(10)},
+				newTestRunModel("1.1.0.0", models.StateSuccess, "run-1", at(0)),
+			},
+			runID: "run-1",
+			ok:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runID, ok := lastRunID(tt.savedMigrations)
+			if runID != tt.runID || ok != tt.ok {
+				t.Fatalf("expected (%q, %v), got (%q, %v)", tt.runID, tt.ok, runID, ok)
+			}
+		})
+	}
+}
+
+func TestCheckLaterMigrations(t *testing.T) {
+	savedMigrations := []models.MigrationModel{
+		newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.3.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.4.0.0", models.StateUndone),
+		newTestModel(TypeRepeatable, "1.9.0.0", models.StateSuccess),
+	}
+
+	tests := []struct {
+		name    string
+		planned []int
+		err     error
+	}{
+		{name: "latest migrations", planned: []int{3, 2}},
+		{name: "all versioned migrations", planned: []int{3, 2, 1}},
+		{name: "later migration is not planned", planned: []int{2, 1}, err: ErrRollbackOutOfOrder},
+		{name: "gap in planned migrations", planned: []int{3, 1}, err: ErrRollbackOutOfOrder},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := newMigrationsPlan()
+			for _, i := range tt.planned {
+				plan.migrationsToRun.PushBack(savedMigrations[i])
+			}
+
+			err := checkLaterMigrations(plan, savedMigrations)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package go_migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"strings"
+)
+
+var (
+	ErrMigrationNotApplied    = errors.New("migration is not applied")
+	ErrLaterMigrationsApplied = errors.New("later migrations are applied, force is required to undo migration")
+)
+
+// UndoMigration откатывает одну успешно выполненную миграцию типа TypeVersioned с версией version и помечает ее как
+// models.StateReverted, не затрагивая миграции с большими версиями. Если такие миграции выполнены, они могут зависеть
+// от откатываемой миграции, поэтому откат выполняется только при force = true, иначе возвращается
+// ErrLaterMigrationsApplied. После отката сохраняется максимальная версия оставшихся выполненных миграций.
+// Откаченная миграция повторно выполняется Migrate только при WithReapplyUndone, в отличие от миграций, откаченных
+// Downgrade, которые выполняются следующим Migrate.
+// Если задан Locker (см. WithLocker), операция выполняется под блокировкой.
+//
+// Возвращает ErrNoSystemTables, если системные таблицы не созданы, ErrMigrationNotApplied, если миграция не сохранена
+// или не выполнена успешно, *DowngradeBlockedError, если миграция не зарегистрирована или не может быть откачена,
+// и *MigrationExecutionError при ошибке отката.
+func (m *MigrationManager) UndoMigration(version string, force bool) error {
+	return m.UndoMigrationContext(context.Background(), version, force)
+}
+
+// UndoMigrationContext выполняет UndoMigration с учетом контекста (см. DowngradeContext).
+func (m *MigrationManager) UndoMigrationContext(ctx context.Context, version string, force bool) error {
+	undoVersion, err := parseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.undoMigration(ctx, undoVersion, force)
+	})
+}
+
+func (m *MigrationManager) undoMigration(ctx context.Context, version Version, force bool) error {
+	m.logger.Println("Preparing undo of migration, version:", version)
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkGroupTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	db := m.primaryDB(ctx)
+
+	if !repository.HasVersionTable(db) || !repository.HasMigrationsTable(db) {
+		return ErrNoSystemTables
+	}
+
+	err = repository.UpgradeMigrationsTable(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.recoverInterruptedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	err = m.execute(ctx, db, func(db, stateDB *gorm.DB) error {
+		return m.executeUndoMigration(ctx, db, stateDB, version, force)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Println("Undo completed")
+	return nil
+}
+
+func (m *MigrationManager) executeUndoMigration(
+	ctx context.Context,
+	db, stateDB *gorm.DB,
+	version Version,
+	force bool,
+) error {
+	savedMigrations, err := m.loadSavedMigrations(db, repository.OrderASC)
+	if err != nil {
+		return err
+	}
+
+	var migrationModel models.MigrationModel
+	found := false
+	laterApplied := make([]string, 0)
+	for _, savedModel := range savedMigrations {
+		if savedModel.Type != string(TypeVersioned) {
+			continue
+		}
+
+		savedVersion := mustParseVersion(savedModel.Version)
+		if savedVersion == version {
+			migrationModel = savedModel
+			found = true
+		}
+		if savedVersion.MoreThan(version) && savedModel.State == models.StateSuccess {
+			laterApplied = append(laterApplied, savedModel.Version)
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%w: versioned migration %s is not saved", ErrMigrationNotApplied, version)
+	}
+	if migrationModel.State != models.StateSuccess {
+		return fmt.Errorf("%w: version %s, state %s", ErrMigrationNotApplied, version, migrationModel.State)
+	}
+	if len(laterApplied) != 0 {
+		if !force {
+			return fmt.Errorf(
+				"%w: version %s, later versions [%s]",
+				ErrLaterMigrationsApplied, version, strings.Join(laterApplied, ", "),
+			)
+		}
+		m.logger.Println("Undoing migration while later migrations are applied:", strings.Join(laterApplied, ", "))
+	}
+
+	plan := newMigrationsPlan()
+	plan.migrationsToRun.PushBack(migrationModel)
+
+	err = m.validateDowngradePlan(plan, savedMigrations, previousVersion(migrationModel, savedMigrations))
+	if err != nil {
+		return err
+	}
+
+	// миграция плана проверена в validateDowngradePlan
+	migration, _ := m.findMigration(migrationModel)
+
+	err = m.saveStateRunning(stateDB, migrationModel)
+	if err != nil {
+		return err
+	}
+
+	err = m.executeDowngrade(db, migrationModel, migration)
+	if err != nil && ctx.Err() != nil {
+		return m.saveStateOnInterruptedMigration(stateDB, migrationModel, migration, migrationModel.State, err)
+	}
+	if err != nil {
+		return m.saveStateOnFailedDowngrade(stateDB, migrationModel, migration, migrationModel.State, err)
+	}
+
+	err = repository.UpdateMigrationStateExecuted(stateDB, &migrationModel, models.StateReverted, migration.checksum)
+	if err != nil {
+		return err
+	}
+
+	return repository.SaveVersion(stateDB, appliedVersion(savedMigrations, migrationModel).String())
+}
+
+// appliedVersion возвращает максимальную версию успешно выполненных миграций типов TypeBaseline и TypeVersioned,
+// кроме откаченной миграции undoneModel, или нулевую версию, если таких миграций нет.
+func appliedVersion(savedMigrations []models.MigrationModel, undoneModel models.MigrationModel) Version {
+	version := Version{}
+	for _, migrationModel := range savedMigrations {
+		if migrationModel.Type == string(TypeRepeatable) || migrationModel.Id == undoneModel.Id {
+			continue
+		}
+		if migrationModel.State != models.StateSuccess {
+			continue
+		}
+
+		migrationVersion := mustParseVersion(migrationModel.Version)
+		if migrationVersion.MoreThan(version) {
+			version = migrationVersion
+		}
+	}
+	return version
+}
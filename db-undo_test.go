@@ -0,0 +1,67 @@
+package go_migrator
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"testing"
+)
+
+func TestAppliedVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		savedMigrations []models.MigrationModel
+		undone          models.MigrationModel
+		expected        string
+	}{
+		{
+			name: "undo top migration",
+			savedMigrations: []models.MigrationModel{
+				newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+			},
+			undone:   newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+			expected: "1.1.0.0",
+		},
+		{
+			name: "undo middle migration",
+			savedMigrations: []models.MigrationModel{
+				newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+			},
+			undone:   newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+			expected: "1.2.0.0",
+		},
+		{
+			name: "not applied and repeatable migrations are ignored",
+			savedMigrations: []models.MigrationModel{
+				newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+				newTestModel(TypeVersioned, "1.3.0.0", models.StateReverted),
+				newTestModel(TypeVersioned, "1.4.0.0", models.StateFailure),
+				newTestModel(TypeVersioned, "1.5.0.0", models.StateRegistered),
+				newTestModel(TypeRepeatable, "1.9.0.0", models.StateSuccess),
+			},
+			undone:   newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+			expected: "1.1.0.0",
+		},
+		{
+			name: "undo only migration",
+			savedMigrations: []models.MigrationModel{
+				newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+			},
+			undone:   newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+			expected: "0.0.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version := appliedVersion(tt.savedMigrations, tt.undone)
+			if version.String() != tt.expected {
+				t.Fatalf("expected version %s, got %s", tt.expected, version)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package go_migrator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"strings"
+)
+
+var (
+	ErrMigrationNotFound      = errors.New("migration not found")
+	ErrDuplicateMigration     = errors.New("migration registered twice")
+	ErrOutOfOrderRegistration = errors.New("migration version is lower than saved migrations")
+	ErrNotVersionedMigrator   = errors.New("versioned migration must satisfy VersionedMigrator interface")
+	ErrNoSystemTables         = errors.New("no migrations table or version table found")
+	ErrInvalidVersion         = errors.New("version parse failed")
+	ErrIrreversibleMigration  = errors.New("migration is irreversible")
+	ErrTargetUnreachable      = errors.New("target version is unreachable by downgrade")
+	ErrDowngradeBlocked       = errors.New("downgrade plan is blocked")
+	ErrConnectionNotFound     = errors.New("connection is not registered in migrations manager")
+	ErrTargetVersionRequired  = errors.New("target version is required for downgrade")
+	ErrInvalidStepCount       = errors.New("step count must be positive")
+)
+
+// MigrationPhase - этап работы с миграцией, на котором возникла ошибка.
+type MigrationPhase string
+
+const (
+	PhaseMigrate   MigrationPhase = "migrate"
+	PhaseDowngrade MigrationPhase = "downgrade"
+)
+
+// MigrationNotFoundError возвращается, если сохраненная миграция, необходимая для выполнения плана, не
+// зарегистрирована. Соответствует ErrMigrationNotFound при проверке через errors.Is.
+type MigrationNotFoundError struct {
+	Type       MigrationType
+	Version    string
+	Identifier uint32
+	Phase      MigrationPhase
+}
+
+func (e *MigrationNotFoundError) Error() string {
+	return fmt.Sprintf(
+		"%s: type %s, version %s, identifier %d, phase %s",
+		ErrMigrationNotFound, e.Type, e.Version, e.Identifier, e.Phase,
+	)
+}
+
+func (e *MigrationNotFoundError) Is(target error) bool {
+	return target == ErrMigrationNotFound
+}
+
+// DuplicateMigrationError возвращается при повторной регистрации миграции с теми же версией и типом.
+// Соответствует ErrDuplicateMigration при проверке через errors.Is.
+type DuplicateMigrationError struct {
+	Type       MigrationType
+	Version    string
+	Identifier uint32
+}
+
+func (e *DuplicateMigrationError) Error() string {
+	return fmt.Sprintf(
+		"%s: type %s, version %s, identifier %d",
+		ErrDuplicateMigration, e.Type, e.Version, e.Identifier,
+	)
+}
+
+func (e *DuplicateMigrationError) Is(target error) bool {
+	return target == ErrDuplicateMigration
+}
+
+// OutOfOrderRegistrationError возвращается при попытке сохранить новую миграцию с версией ниже, чем у уже сохраненных.
+// Соответствует ErrOutOfOrderRegistration при проверке через errors.Is.
+type OutOfOrderRegistrationError struct {
+	Type         MigrationType
+	Version      string
+	Identifier   uint32
+	SavedVersion string
+}
+
+func (e *OutOfOrderRegistrationError) Error() string {
+	return fmt.Sprintf(
+		"%s: type %s, version %s, identifier %d, saved version %s",
+		ErrOutOfOrderRegistration, e.Type, e.Version, e.Identifier, e.SavedVersion,
+	)
+}
+
+func (e *OutOfOrderRegistrationError) Is(target error) bool {
+	return target == ErrOutOfOrderRegistration
+}
+
+// MigrationExecutionError оборачивает ошибку, возникшую при выполнении или откате миграции. Исходная ошибка доступна
+// через errors.Unwrap.
+type MigrationExecutionError struct {
+	Type       MigrationType
+	Version    string
+	Identifier uint32
+	Phase      MigrationPhase
+	Err        error
+}
+
+func (e *MigrationExecutionError) Error() string {
+	return fmt.Sprintf(
+		"%s of migration (type %s, version %s, identifier %d) failed: %s",
+		e.Phase, e.Type, e.Version, e.Identifier, e.Err,
+	)
+}
+
+func (e *MigrationExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// DowngradeBlockedError возвращается, если план Downgrade не может быть выполнен целиком. Содержит все найденные
+// препятствия: *MigrationNotFoundError, ErrNotVersionedMigrator, ErrIrreversibleMigration и ErrTargetUnreachable.
+// Соответствует ErrDowngradeBlocked при проверке через errors.Is.
+type DowngradeBlockedError struct {
+	TargetVersion string
+	Blockers      []error
+}
+
+func (e *DowngradeBlockedError) Error() string {
+	blockers := make([]string, 0, len(e.Blockers))
+	for _, blocker := range e.Blockers {
+		blockers = append(blockers, blocker.Error())
+	}
+	return fmt.Sprintf("%s, target version %s: %s", ErrDowngradeBlocked, e.TargetVersion, strings.Join(blockers, "; "))
+}
+
+func (e *DowngradeBlockedError) Is(target error) bool {
+	return target == ErrDowngradeBlocked
+}
+
+// CompensationError возвращается, если после ошибки миграции были откачены миграции, выполненные в том же запуске
+// (см. WithRollbackOnFailure). Исходная ошибка доступна через errors.Unwrap, ошибки отката - в CompensationErrors.
+type CompensationError struct {
+	Cause              error
+	Compensated        []string
+	CompensationErrors []error
+}
+
+func (e *CompensationError) Error() string {
+	message := fmt.Sprintf("%s; rolled back versions: [%s]", e.Cause, strings.Join(e.Compensated, ", "))
+	if len(e.CompensationErrors) == 0 {
+		return message
+	}
+
+	compensationMessages := make([]string, 0, len(e.CompensationErrors))
+	for _, err := range e.CompensationErrors {
+		compensationMessages = append(compensationMessages, err.Error())
+	}
+	return fmt.Sprintf("%s; rollback failed: %s", message, strings.Join(compensationMessages, "; "))
+}
+
+func (e *CompensationError) Unwrap() error {
+	return e.Cause
+}
+
+func newMigrationNotFoundError(migrationModel models.MigrationModel, phase MigrationPhase) *MigrationNotFoundError {
+	return &MigrationNotFoundError{
+		Type:       MigrationType(migrationModel.Type),
+		Version:    migrationModel.Version,
+		Identifier: getMigrationIdentifier(migrationModel.Version, migrationModel.Type),
+		Phase:      phase,
+	}
+}
+
+func newMigrationExecutionError(
+	migrationModel models.MigrationModel,
+	phase MigrationPhase,
+	err error,
+) *MigrationExecutionError {
+	return &MigrationExecutionError{
+		Type:       MigrationType(migrationModel.Type),
+		Version:    migrationModel.Version,
+		Identifier: getMigrationIdentifier(migrationModel.Version, migrationModel.Type),
+		Phase:      phase,
+		Err:        err,
+	}
+}
@@ -13,9 +13,17 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	migrator.RegisterMigration(NewInitialMigration())
-	migrator.RegisterMigration(NewRepeatableMigration())
-	migrator.RegisterMigration(NewVersionedMigration())
+	migrations := []*go_migrator.Migration{
+		NewInitialMigration(),
+		NewRepeatableMigration(),
+		NewVersionedMigration(),
+	}
+	for _, migration := range migrations {
+		err = migrator.RegisterMigration(migration)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 
 	err = migrator.Downgrade()
 	if err != nil {
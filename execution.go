@@ -0,0 +1,305 @@
+package go_migrator
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// ExecutionSettings описывает параметры выполнения миграции. Нулевые значения означают, что параметр не задан и
+// используется значение по умолчанию базы данных. Опции WithIsolationLevel, WithLockTimeout и WithStatementTimeout
+// задают параметр миграции явно, в т.ч. нулевым значением: WithStatementTimeout(0) отключает statement_timeout,
+// заданный в MigrationManager. Таймауты передаются базе данных в миллисекундах с округлением вверх, т.к. нулевое
+// значение таймаута в базе данных означает его отсутствие.
+type ExecutionSettings struct {
+	// IsolationLevel - уровень изоляции транзакции миграции.
+	IsolationLevel sql.IsolationLevel
+	// LockTimeout - максимальное время ожидания блокировки (lock_timeout).
+	LockTimeout time.Duration
+	// StatementTimeout - максимальное время выполнения запроса (statement_timeout).
+	StatementTimeout time.Duration
+
+	// explicit - параметры, заданные явно, даже если их значение нулевое
+	explicit executionSetting
+}
+
+// executionSetting - набор флагов параметров выполнения.
+type executionSetting uint8
+
+const (
+	settingIsolationLevel executionSetting = 1 << iota
+	settingLockTimeout
+	settingStatementTimeout
+)
+
+func (s ExecutionSettings) String() string {
+	return fmt.Sprintf(
+		"isolation level: %s, lock_timeout: %s, statement_timeout: %s",
+		s.IsolationLevel,
+		formatTimeout(s.LockTimeout, s.isSet(settingLockTimeout)),
+		formatTimeout(s.StatementTimeout, s.isSet(settingStatementTimeout)),
+	)
+}
+
+func (s ExecutionSettings) isEmpty() bool {
+	return s == ExecutionSettings{}
+}
+
+// isSet возвращает true, если параметр задан ненулевым значением или явно.
+func (s ExecutionSettings) isSet(setting executionSetting) bool {
+	if s.explicit&setting != 0 {
+		return true
+	}
+
+	switch setting {
+	case settingIsolationLevel:
+		return s.IsolationLevel != sql.LevelDefault
+	case settingLockTimeout:
+		return s.LockTimeout != 0
+	case settingStatementTimeout:
+		return s.StatementTimeout != 0
+	}
+	return false
+}
+
+// override возвращает параметры, в которых заданные в settings значения заменяют текущие. Явно заданное нулевое
+// значение также заменяет текущее, например отключает таймаут.
+func (s ExecutionSettings) override(settings ExecutionSettings) ExecutionSettings {
+	if settings.isSet(settingIsolationLevel) {
+		s.IsolationLevel = settings.IsolationLevel
+		s.explicit |= settings.explicit & settingIsolationLevel
+	}
+	if settings.isSet(settingLockTimeout) {
+		s.LockTimeout = settings.LockTimeout
+		s.explicit |= settings.explicit & settingLockTimeout
+	}
+	if settings.isSet(settingStatementTimeout) {
+		s.StatementTimeout = settings.StatementTimeout
+		s.explicit |= settings.explicit & settingStatementTimeout
+	}
+	return s
+}
+
+func (s ExecutionSettings) txOptions() []*sql.TxOptions {
+	if s.IsolationLevel == sql.LevelDefault {
+		return nil
+	}
+	return []*sql.TxOptions{{Isolation: s.IsolationLevel}}
+}
+
+// runMigrator выполняет fn в соответствии с режимом транзакции миграции и ее параметрами выполнения.
+// Внутри групповой транзакции параметры устанавливаются через SET LOCAL и сбрасываются после миграции, уровень
+// изоляции определяется групповой транзакцией. Для нетранзакционной миграции параметры устанавливаются на
+// выделенном соединении и сбрасываются после ее выполнения. Если задан инициализатор сессии (WithSessionInit),
+// он выполняется в начале транзакции миграции или перед нетранзакционной миграцией.
+func (m *MigrationManager) runMigrator(db *gorm.DB, migration *Migration, fn func(*gorm.DB) error) error {
+	db = m.migrationDB(db, migration)
+
+	settings := m.executionSettings.override(migration.executionSettings)
+	if !settings.isEmpty() {
+		m.logger.Println("Execution settings:", settings)
+	}
+	ownTransaction := migration.transaction && !m.groupTransaction
+	if !ownTransaction && migration.executionSettings.isSet(settingIsolationLevel) {
+		m.logger.Println("Isolation level is ignored for migration without own transaction")
+	}
+
+	switch {
+	case m.groupTransaction:
+		// сессия групповой транзакции инициализирована при ее открытии
+		err := setTimeouts(db, "SET LOCAL", settings)
+		if err != nil {
+			return err
+		}
+
+		err = fn(db)
+		if err != nil {
+			return err
+		}
+		return resetTimeouts(db, "SET LOCAL", settings)
+
+	case migration.transaction:
+		return m.withSessionConnection(db, func(session *gorm.DB) error {
+			return session.Transaction(func(tx *gorm.DB) error {
+				err := m.initSession(tx)
+				if err != nil {
+					return err
+				}
+
+				err = setTimeouts(tx, "SET LOCAL", settings)
+				if err != nil {
+					return err
+				}
+				return fn(tx)
+			}, settings.txOptions()...)
+		})
+
+	case len(m.sessionInit) != 0:
+		return m.withSessionConnection(db, func(session *gorm.DB) error {
+			err := m.initSession(session)
+			if err != nil {
+				return err
+			}
+
+			// соединение не возвращается в пул, поэтому параметры сессии не сбрасываются
+			err = setTimeouts(session, "SET", settings)
+			if err != nil {
+				return err
+			}
+			return fn(session)
+		})
+
+	default:
+		if !settings.isSet(settingLockTimeout) && !settings.isSet(settingStatementTimeout) {
+			return fn(db)
+		}
+
+		return db.Connection(func(conn *gorm.DB) error {
+			session := m.forcePrimary(conn.Session(&gorm.Session{NewDB: true}))
+
+			err := setTimeouts(session, "SET", settings)
+			if err != nil {
+				return err
+			}
+
+			migrateErr := fn(session)
+			// соединение возвращается в пул, поэтому параметры сессии сбрасываются даже после ошибки миграции
+			err = resetTimeouts(session, "SET", settings)
+			if migrateErr != nil {
+				return migrateErr
+			}
+			return err
+		})
+	}
+}
+
+// migrationDB возвращает соединение, на котором выполняется миграция: именованное соединение, заданное с помощью
+// WithMigrationConnection, или соединение текущего запуска. Если задан WithPrimaryResolver, запросы миграции
+// направляются на основной сервер, чтобы миграция не была выполнена на реплике.
+func (m *MigrationManager) migrationDB(db *gorm.DB, migration *Migration) *gorm.DB {
+	if migration.connection != "" {
+		m.logger.Println("Using connection:", migration.connection)
+		db = m.connections[migration.connection].WithContext(db.Statement.Context)
+	}
+
+	return m.forcePrimary(db)
+}
+
+// primaryDB возвращает сессию основного соединения управляющего с контекстом ctx, запросы которой направляются
+// на основной сервер (см. WithPrimaryResolver). Через нее читается и сохраняется состояние миграций, чтобы план
+// и версия не строились по отстающей реплике.
+func (m *MigrationManager) primaryDB(ctx context.Context) *gorm.DB {
+	return m.forcePrimary(m.db.WithContext(ctx))
+}
+
+// forcePrimary возвращает сессию, все запросы которой направляются на основной сервер (см. WithPrimaryResolver).
+func (m *MigrationManager) forcePrimary(db *gorm.DB) *gorm.DB {
+	if m.primaryResolver == nil {
+		return db
+	}
+	return m.primaryResolver(db).Session(&gorm.Session{})
+}
+
+// withSessionConnection выполняет fn на выделенном соединении, если задан инициализатор сессии. После выполнения
+// соединение закрывается, а не возвращается в пул, т.к. инициализатор мог изменить параметры сессии (роль,
+// search_path), которые иначе достались бы другим запросам приложения.
+func (m *MigrationManager) withSessionConnection(db *gorm.DB, fn func(*gorm.DB) error) error {
+	if len(m.sessionInit) == 0 {
+		return fn(db)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx := db.Statement.Context
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer discardConnection(conn)
+
+	return fn(m.forcePrimary(pinConnection(ctx, db, conn)))
+}
+
+// initSession выполняет инициализатор сессии.
+func (m *MigrationManager) initSession(db *gorm.DB) error {
+	for _, init := range m.sessionInit {
+		err := init(db)
+		if err != nil {
+			return fmt.Errorf("session init failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// discardConnection закрывает соединение, не возвращая его в пул.
+func discardConnection(conn *sql.Conn) {
+	// driver.ErrBadConn, возвращенная из Raw, приводит к закрытию соединения пулом database/sql
+	_ = conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+	_ = conn.Close()
+}
+
+// setTimeouts устанавливает заданные таймауты. Явно заданный нулевой таймаут устанавливается, чтобы отключить
+// таймаут, заданный в параметрах сессии или базы данных.
+func setTimeouts(db *gorm.DB, command string, settings ExecutionSettings) error {
+	if settings.isSet(settingLockTimeout) {
+		err := db.Exec(fmt.Sprintf("%s lock_timeout = %d", command, timeoutMilliseconds(settings.LockTimeout))).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.isSet(settingStatementTimeout) {
+		err := db.Exec(fmt.Sprintf("%s statement_timeout = %d", command, timeoutMilliseconds(settings.StatementTimeout))).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func resetTimeouts(db *gorm.DB, command string, settings ExecutionSettings) error {
+	if settings.isSet(settingLockTimeout) {
+		err := db.Exec(command + " lock_timeout TO DEFAULT").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.isSet(settingStatementTimeout) {
+		err := db.Exec(command + " statement_timeout TO DEFAULT").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// timeoutMilliseconds возвращает таймаут в миллисекундах, округленный вверх, чтобы таймаут меньше миллисекунды
+// не превратился в нулевой, отключающий ограничение.
+func timeoutMilliseconds(timeout time.Duration) int64 {
+	milliseconds := timeout.Milliseconds()
+	if timeout%time.Millisecond > 0 {
+		milliseconds++
+	}
+	return milliseconds
+}
+
+func formatTimeout(timeout time.Duration, set bool) string {
+	if timeout == 0 {
+		if set {
+			return "disabled"
+		}
+		return "default"
+	}
+	return timeout.String()
+}
@@ -0,0 +1,73 @@
+package go_migrator
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestTimeoutMilliseconds(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		expected int64
+	}{
+		{name: "zero", timeout: 0, expected: 0},
+		{name: "sub-millisecond", timeout: time.Microsecond, expected: 1},
+		{name: "exact milliseconds", timeout: 250 * time.Millisecond, expected: 250},
+		{name: "fractional milliseconds", timeout: 1500 * time.Microsecond, expected: 2},
+		{name: "seconds", timeout: 3 * time.Second, expected: 3000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			milliseconds := timeoutMilliseconds(tt.timeout)
+			if milliseconds != tt.expected {
+				t.Fatalf("expected %d ms, got %d ms", tt.expected, milliseconds)
+			}
+		})
+	}
+}
+
+func TestExecutionSettingsOverride(t *testing.T) {
+	manager := ExecutionSettings{
+		IsolationLevel:   sql.LevelSerializable,
+		LockTimeout:      time.Second,
+		StatementTimeout: time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		options  []MigrationOption
+		expected string
+	}{
+		{
+			name:     "not set",
+			expected: "isolation level: Serializable, lock_timeout: 1s, statement_timeout: 1m0s",
+		},
+		{
+			name:     "override",
+			options:  []MigrationOption{WithLockTimeout(5 * time.Second), WithIsolationLevel(sql.LevelReadCommitted)},
+			expected: "isolation level: Read Committed, lock_timeout: 5s, statement_timeout: 1m0s",
+		},
+		{
+			name:     "explicit zero",
+			options:  []MigrationOption{WithStatementTimeout(0), WithIsolationLevel(sql.LevelDefault)},
+			expected: "isolation level: Default, lock_timeout: 1s, statement_timeout: disabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migration := &Migration{}
+			for _, option := range tt.options {
+				option(migration)
+			}
+
+			settings := manager.override(migration.executionSettings)
+			if settings.String() != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, settings.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package go_migrator
+
+import (
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"log"
+	"strings"
+)
+
+// DecisionReason - правило планировщика, определившее, попала ли миграция в план.
+type DecisionReason string
+
+const (
+	// ReasonLatestBaseline - последняя миграция типа TypeBaseline не выше target версии, успешных миграций типа
+	// TypeBaseline нет.
+	ReasonLatestBaseline DecisionReason = "latest-baseline"
+	// ReasonBaselineNotRequired - уже есть успешно выполненная миграция типа TypeBaseline.
+	ReasonBaselineNotRequired DecisionReason = "baseline-not-required"
+	// ReasonPending - миграция выше сохраненной версии и не выше target версии еще не выполнена.
+	ReasonPending DecisionReason = "pending"
+	// ReasonAlreadySuccessful - миграция уже выполнена успешно.
+	ReasonAlreadySuccessful DecisionReason = "already-successful"
+	// ReasonSkipped - миграция пропущена после выполнения миграции типа TypeBaseline.
+	ReasonSkipped DecisionReason = "skipped"
+	// ReasonFailedIgnored - миграция типа TypeVersioned завершилась ошибкой, которая разрешена WithAllowFailure.
+	ReasonFailedIgnored DecisionReason = "failed-ignored"
+	// ReasonAboveTarget - версия миграции выше target версии.
+	ReasonAboveTarget DecisionReason = "above-target"
+	// ReasonBelowSavedVersion - версия миграции не выше сохраненной версии.
+	ReasonBelowSavedVersion DecisionReason = "below-saved-version"
+	// ReasonShadowedByBaseline - версия миграции ниже запланированной миграции типа TypeBaseline.
+	ReasonShadowedByBaseline DecisionReason = "shadowed-by-baseline"
+	// ReasonChecksumUnchanged - контрольная сумма миграции типа TypeRepeatable не изменилась.
+	ReasonChecksumUnchanged DecisionReason = "checksum-unchanged"
+	// ReasonChecksumChanged - контрольная сумма миграции типа TypeRepeatable изменилась.
+	ReasonChecksumChanged DecisionReason = "checksum-changed"
+	// ReasonRepeatUnconditional - миграция зарегистрирована с WithRepeatUnconditional.
+	ReasonRepeatUnconditional DecisionReason = "repeat-unconditional"
+	// ReasonNotFound - сохраненная миграция не зарегистрирована.
+	ReasonNotFound DecisionReason = "not-found"
+	// ReasonNotVersioned - откатываются только миграции типа TypeVersioned.
+	ReasonNotVersioned DecisionReason = "not-versioned"
+	// ReasonAboveSavedVersion - версия миграции выше сохраненной версии, миграция не применялась.
+	ReasonAboveSavedVersion DecisionReason = "above-saved-version"
+	// ReasonNotAboveTarget - версия миграции не выше target версии, откат не требуется.
+	ReasonNotAboveTarget DecisionReason = "not-above-target"
+	// ReasonUndo - успешно выполненная миграция выше target версии откатывается.
+	ReasonUndo DecisionReason = "undo"
+	// ReasonNotApplied - миграция не была применена, т.к. завершилась разрешенной ошибкой (см. WithAllowFailure),
+	// поэтому не откатывается.
+	ReasonNotApplied DecisionReason = "not-applied"
+	// ReasonAlreadyUndone - миграция уже откачена.
+	ReasonAlreadyUndone DecisionReason = "already-undone"
+	// ReasonReapplyUndone - миграция, откаченная UndoMigration, выполняется повторно (см. WithReapplyUndone).
+	ReasonReapplyUndone DecisionReason = "reapply-undone"
+	// ReasonReverted - миграция откачена UndoMigration и без WithReapplyUndone повторно не выполняется.
+	ReasonReverted DecisionReason = "reverted"
+	// ReasonStepLimit - миграция не попала в план из-за ограничения количества шагов (MigrateSteps, DowngradeSteps).
+	ReasonStepLimit DecisionReason = "step-limit"
+	// ReasonNotInRun - миграция выполнена не в откатываемом запуске (RollbackRun, RollbackLastRun) или не выполнена
+	// успешно.
+	ReasonNotInRun DecisionReason = "not-in-run"
+	// ReasonExecutedBefore - миграция выполнена не позже момента, до которого выполняется RollbackTo, или не выполнена
+	// успешно.
+	ReasonExecutedBefore DecisionReason = "executed-before"
+)
+
+// PlanDecision описывает решение планировщика по одной сохраненной миграции.
+type PlanDecision struct {
+	Type    MigrationType         `json:"type"`
+	Version string                `json:"version"`
+	State   models.MigrationState `json:"state"`
+	Planned bool                  `json:"planned"`
+	Reason  DecisionReason        `json:"reason"`
+	Details string                `json:"details,omitempty"`
+}
+
+func (d PlanDecision) String() string {
+	decision := "not planned"
+	if d.Planned {
+		decision = "planned"
+	}
+
+	description := fmt.Sprintf("%s %s (%s) %s: %s", d.Type, d.Version, d.State, decision, d.Reason)
+	if d.Details != "" {
+		description += " (" + d.Details + ")"
+	}
+	return description
+}
+
+// Explain возвращает решения планировщика по всем сохраненным миграциям, по одному на строку.
+func (p *Plan) Explain() string {
+	lines := make([]string, 0, len(p.Decisions))
+	for _, decision := range p.Decisions {
+		lines = append(lines, decision.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// decisionRecorder запоминает решения планировщика и выводит их в лог, если задан WithPlanExplanation.
+type decisionRecorder struct {
+	logger *log.Logger
+	log    bool
+
+	decisions []PlanDecision
+}
+
+func (r *decisionRecorder) plan(migrationModel models.MigrationModel, reason DecisionReason, details string) {
+	r.record(migrationModel, true, reason, details)
+}
+
+func (r *decisionRecorder) skip(migrationModel models.MigrationModel, reason DecisionReason, details string) {
+	r.record(migrationModel, false, reason, details)
+}
+
+func (r *decisionRecorder) record(
+	migrationModel models.MigrationModel,
+	planned bool,
+	reason DecisionReason,
+	details string,
+) {
+	decision := PlanDecision{
+		Type:    MigrationType(migrationModel.Type),
+		Version: migrationModel.Version,
+		State:   migrationModel.State,
+		Planned: planned,
+		Reason:  reason,
+		Details: details,
+	}
+	r.decisions = append(r.decisions, decision)
+
+	if r.log {
+		r.logger.Println("Plan decision:", decision)
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type LockModel struct {
+	Name       string `gorm:"primaryKey"`
+	Owner      string
+	Hostname   string
+	AcquiredAt time.Time
+	ExpiresAt  time.Time
+}
+
+func (v LockModel) TableName() string {
+	return "migrations_lock"
+}
@@ -11,6 +11,19 @@ const (
 	StateRegistered MigrationState = "registered"
 	StateSkipped    MigrationState = "skipped"
 	StateNotFound   MigrationState = "not found"
+	// StateFailedIgnored - миграция завершилась ошибкой, но ей разрешено завершаться с ошибкой
+	StateFailedIgnored MigrationState = "failed-ignored"
+	// StateRunning - миграция выполняется или выполнение было прервано аварийным завершением процесса
+	StateRunning MigrationState = "running"
+	// StateReverted - миграция откачена отдельно от остальных с помощью UndoMigration
+	StateReverted MigrationState = "reverted"
+)
+
+type SavepointState string
+
+const (
+	SavepointReleased   SavepointState = "released"
+	SavepointRolledBack SavepointState = "rolled back"
 )
 
 type MigrationModel struct {
@@ -23,6 +36,18 @@ type MigrationModel struct {
 	ExecutedOn   *time.Time
 	Checksum     string
 	State        MigrationState
+	ErrorMessage string
+	Attempts     int
+
+	Owner         string
+	StartedOn     *time.Time
+	PreviousState MigrationState
+
+	Savepoint      string
+	SavepointState SavepointState
+
+	// RunId - идентификатор запуска, в котором миграция была успешно выполнена
+	RunId string
 }
 
 func (v MigrationModel) TableName() string {
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+func TryAdvisoryLock(db *gorm.DB, key int64) (bool, error) {
+	var acquired bool
+	err := db.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&acquired).Error
+	return acquired, err
+}
+
+func AdvisoryUnlock(db *gorm.DB, key int64) (bool, error) {
+	var released bool
+	err := db.Raw("SELECT pg_advisory_unlock(?)", key).Scan(&released).Error
+	return released, err
+}
+
+type AcquireLeaseRequest struct {
+	Name     string
+	Owner    string
+	Hostname string
+	Duration time.Duration
+}
+
+// TryAcquireLease захватывает аренду блокировки, если она свободна, просрочена или уже принадлежит владельцу.
+func TryAcquireLease(db *gorm.DB, request AcquireLeaseRequest) (bool, error) {
+	now := time.Now().UTC()
+	lease := models.LockModel{
+		Name:       request.Name,
+		Owner:      request.Owner,
+		Hostname:   request.Hostname,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(request.Duration),
+	}
+
+	result := db.Model(&models.LockModel{}).
+		Where("name = ? AND (expires_at < ? OR owner = ?)", request.Name, now, request.Owner).
+		Updates(map[string]interface{}{
+			"owner":       lease.Owner,
+			"hostname":    lease.Hostname,
+			"acquired_at": lease.AcquiredAt,
+			"expires_at":  lease.ExpiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	_, err := GetLease(db, request.Name)
+	if err == nil {
+		// аренда действительна и принадлежит другому владельцу
+		return false, nil
+	}
+	if err != ErrNotFound {
+		return false, err
+	}
+
+	err = db.Create(&lease).Error
+	if err != nil {
+		// запись могла быть создана другим владельцем одновременно с нами
+		if _, getErr := GetLease(db, request.Name); getErr == nil {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RenewLease продлевает аренду, если она все еще принадлежит владельцу.
+func RenewLease(db *gorm.DB, name, owner string, duration time.Duration) (bool, error) {
+	result := db.Model(&models.LockModel{}).
+		Where("name = ? AND owner = ?", name, owner).
+		Update("expires_at", time.Now().UTC().Add(duration))
+	return result.RowsAffected == 1, result.Error
+}
+
+func ReleaseLease(db *gorm.DB, name, owner string) error {
+	return db.Where("name = ? AND owner = ?", name, owner).Delete(&models.LockModel{}).Error
+}
+
+func GetLease(db *gorm.DB, name string) (models.LockModel, error) {
+	var lease models.LockModel
+	err := db.Where("name = ?", name).Take(&lease).Error
+
+	switch err {
+	case gorm.ErrRecordNotFound:
+		return lease, ErrNotFound
+	default:
+		return lease, err
+	}
+}
+
+func HasLockTable(db *gorm.DB) bool {
+	return db.Migrator().HasTable(models.LockModel{}.TableName())
+}
+
+func CreateLockTable(db *gorm.DB) error {
+	return db.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations_lock (
+			name VARCHAR(255) PRIMARY KEY,
+			owner VARCHAR(255),
+			hostname VARCHAR(255),
+			acquired_at TIMESTAMP,
+			expires_at TIMESTAMP
+		)
+	`).Error
+}
@@ -25,11 +25,64 @@ func UpdateMigrationState(db *gorm.DB, model *models.MigrationModel, state model
 }
 
 func UpdateMigrationStateExecuted(db *gorm.DB, model *models.MigrationModel, state models.MigrationState, checksum string) error {
+	return UpdateMigrationStateExecutedInRun(db, model, state, checksum, "")
+}
+
+// UpdateMigrationStateExecutedInRun сохраняет состояние выполненной миграции вместе с идентификатором запуска одним
+// запросом. Пустой runId не изменяет сохраненный идентификатор.
+func UpdateMigrationStateExecutedInRun(
+	db *gorm.DB,
+	model *models.MigrationModel,
+	state models.MigrationState,
+	checksum string,
+	runId string,
+) error {
 	now := time.Now().UTC()
 	return db.Model(model).Updates(models.MigrationModel{
 		ExecutedOn: &now,
 		State:      state,
 		Checksum:   checksum,
+		RunId:      runId,
+	}).Error
+}
+
+// UpdateMigrationStateRunning помечает миграцию выполняемой, запоминая владельца, время начала и предыдущее состояние.
+func UpdateMigrationStateRunning(db *gorm.DB, model *models.MigrationModel, owner string) error {
+	now := time.Now().UTC()
+	return db.Model(model).Updates(models.MigrationModel{
+		State:         models.StateRunning,
+		Owner:         owner,
+		StartedOn:     &now,
+		PreviousState: model.State,
+	}).Error
+}
+
+func UpdateMigrationStateFailed(db *gorm.DB, model *models.MigrationModel, state models.MigrationState, cause error) error {
+	now := time.Now().UTC()
+	return db.Model(model).Updates(models.MigrationModel{
+		ExecutedOn:   &now,
+		State:        state,
+		ErrorMessage: cause.Error(),
+	}).Error
+}
+
+// UpdateMigrationStateInterrupted возвращает миграции состояние state, сохраняя причину прерывания, время выполнения
+// не изменяется.
+func UpdateMigrationStateInterrupted(db *gorm.DB, model *models.MigrationModel, state models.MigrationState, cause error) error {
+	return db.Model(model).Updates(models.MigrationModel{
+		State:        state,
+		ErrorMessage: cause.Error(),
+	}).Error
+}
+
+func UpdateMigrationAttempts(db *gorm.DB, model *models.MigrationModel, attempts int) error {
+	return db.Model(model).Update("attempts", attempts).Error
+}
+
+func UpdateMigrationSavepoint(db *gorm.DB, model *models.MigrationModel, savepoint string, state models.SavepointState) error {
+	return db.Model(model).Updates(models.MigrationModel{
+		Savepoint:      savepoint,
+		SavepointState: state,
 	}).Error
 }
 
@@ -41,10 +94,11 @@ type SaveMigrationRequest struct {
 	State       models.MigrationState
 }
 
-func SaveMigration(db *gorm.DB, request SaveMigrationRequest) (models.MigrationModel, error) {
+// NewMigrationModel создает модель миграции, не сохраняя ее.
+func NewMigrationModel(request SaveMigrationRequest) models.MigrationModel {
 	h := fnv.New32a()
 	_, _ = h.Write([]byte(request.Type + request.Version))
-	migration := models.MigrationModel{
+	return models.MigrationModel{
 		Id:           h.Sum32(),
 		Rank:         request.Rank,
 		Type:         request.Type,
@@ -53,7 +107,10 @@ func SaveMigration(db *gorm.DB, request SaveMigrationRequest) (models.MigrationM
 		RegisteredOn: time.Now().UTC(),
 		State:        request.State,
 	}
+}
 
+func SaveMigration(db *gorm.DB, request SaveMigrationRequest) (models.MigrationModel, error) {
+	migration := NewMigrationModel(request)
 	return migration, db.Save(&migration).Error
 }
 
@@ -72,7 +129,35 @@ func CreateMigrationsTable(db *gorm.DB) error {
 			registered_on TIMESTAMPTZ,
 			executed_on TIMESTAMPTZ,
 			checksum TEXT,
-			state TEXT
+			state TEXT,
+			error_message TEXT,
+			attempts INTEGER,
+			owner TEXT,
+			started_on TIMESTAMPTZ,
+			previous_state TEXT,
+			savepoint TEXT,
+			savepoint_state TEXT,
+			run_id TEXT
 		)
 	`).Error
 }
+
+// UpgradeMigrationsTable добавляет в таблицу migrations колонки, появившиеся в более новых версиях библиотеки.
+func UpgradeMigrationsTable(db *gorm.DB) error {
+	columns := []string{
+		"ErrorMessage", "Attempts", "Owner", "StartedOn", "PreviousState", "Savepoint", "SavepointState", "RunId",
+	}
+
+	for _, column := range columns {
+		if db.Migrator().HasColumn(&models.MigrationModel{}, column) {
+			continue
+		}
+
+		err := db.Migrator().AddColumn(&models.MigrationModel{}, column)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
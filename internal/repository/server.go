@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+)
+
+func IsInRecovery(db *gorm.DB) (bool, error) {
+	var inRecovery bool
+	err := db.Raw("SELECT pg_is_in_recovery()").Scan(&inRecovery).Error
+	return inRecovery, err
+}
+
+func GetServerVersionNum(db *gorm.DB) (int, error) {
+	var versionNum int
+	err := db.Raw("SELECT current_setting('server_version_num')::int").Scan(&versionNum).Error
+	return versionNum, err
+}
+
+func GetServerVersion(db *gorm.DB) (string, error) {
+	var version string
+	err := db.Raw("SHOW server_version").Scan(&version).Error
+	return version, err
+}
+
+// GetInstalledExtensions возвращает установленные расширения из списка names.
+func GetInstalledExtensions(db *gorm.DB, names []string) ([]string, error) {
+	installed := make([]string, 0)
+	err := db.Raw("SELECT extname FROM pg_extension WHERE extname IN ?", names).Scan(&installed).Error
+	return installed, err
+}
+
+func GetCurrentSchema(db *gorm.DB) (string, error) {
+	var schema string
+	err := db.Raw("SELECT current_schema()").Scan(&schema).Error
+	return schema, err
+}
+
+func GetCurrentUser(db *gorm.DB) (string, error) {
+	var user string
+	err := db.Raw("SELECT current_user").Scan(&user).Error
+	return user, err
+}
+
+func HasSchemaCreatePrivilege(db *gorm.DB, schema string) (bool, error) {
+	var granted bool
+	err := db.Raw("SELECT has_schema_privilege(current_user, ?, 'CREATE')", schema).Scan(&granted).Error
+	return granted, err
+}
@@ -0,0 +1,207 @@
+package go_migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultLeaseName - имя аренды, используемое TableLeaseLocker по умолчанию.
+const DefaultLeaseName = "migrations"
+
+var (
+	ErrInvalidLeaseDuration = errors.New("lease duration is too short")
+)
+
+// heartbeatsPerLease - количество продлений аренды за срок аренды.
+const heartbeatsPerLease = 3
+
+// NewTableLeaseLocker создает Locker на основе записи об аренде в таблице migrations_lock. Подходит для баз данных
+// без advisory lock (SQLite, MySQL).
+// leaseDuration - срок аренды, по истечении которого блокировка считается брошенной и может быть перехвачена.
+// waitTimeout - максимальное время ожидания блокировки, при нулевом значении ожидание не ограничено.
+//
+// Сроки аренды вычисляются по часам приложения, поэтому часы всех экземпляров должны быть синхронизированы с
+// точностью, заметно меньшей leaseDuration.
+//
+// Возвращает ErrInvalidLeaseDuration, если leaseDuration слишком мал для продления аренды в фоне.
+func NewTableLeaseLocker(leaseDuration, waitTimeout time.Duration) (*TableLeaseLocker, error) {
+	heartbeatInterval := leaseDuration / heartbeatsPerLease
+	if heartbeatInterval <= 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLeaseDuration, leaseDuration)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &TableLeaseLocker{
+		name:              DefaultLeaseName,
+		owner:             newOwnerID(),
+		hostname:          hostname,
+		leaseDuration:     leaseDuration,
+		heartbeatInterval: heartbeatInterval,
+		waitTimeout:       waitTimeout,
+		pollInterval:      time.Second,
+	}, nil
+}
+
+// TableLeaseLocker удерживает аренду, продлевая ее в фоне, пока выполняются Migrate и Downgrade. Аренда владельца,
+// который завершился аварийно и перестал ее продлевать, перехватывается после истечения срока.
+// Аренда считается потерянной, если ее перехватил другой владелец или ее не удалось продлить до истечения срока,
+// тогда закрывается канал Lost и выполнение миграций прерывается (см. LeaseLocker).
+type TableLeaseLocker struct {
+	name              string
+	owner             string
+	hostname          string
+	leaseDuration     time.Duration
+	heartbeatInterval time.Duration
+	waitTimeout       time.Duration
+	pollInterval      time.Duration
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+	// heartbeatErr - причина потери аренды, ошибки продления до истечения срока не сохраняются
+	heartbeatErr error
+}
+
+// Lost возвращает канал, который закрывается при потере аренды, захваченной последним вызовом Lock.
+func (l *TableLeaseLocker) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+func (l *TableLeaseLocker) Lock(ctx context.Context, db *gorm.DB) error {
+	if l.stop != nil {
+		return errors.New("lease is already held")
+	}
+
+	if l.waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.waitTimeout)
+		defer cancel()
+	}
+	session := db.WithContext(ctx)
+
+	if !repository.HasLockTable(session) {
+		err := repository.CreateLockTable(session)
+		if err != nil {
+			return err
+		}
+	}
+
+	request := repository.AcquireLeaseRequest{
+		Name:     l.name,
+		Owner:    l.owner,
+		Hostname: l.hostname,
+		Duration: l.leaseDuration,
+	}
+
+	for {
+		acquired, err := repository.TryAcquireLease(session, request)
+		if err != nil {
+			return l.wrapWaitError(ctx, db, err)
+		}
+		if acquired {
+			l.startHeartbeat(db)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return l.wrapWaitError(ctx, db, ctx.Err())
+		case <-time.After(l.pollInterval):
+		}
+	}
+}
+
+func (l *TableLeaseLocker) Unlock(ctx context.Context, db *gorm.DB) error {
+	if l.stop == nil {
+		return nil
+	}
+
+	close(l.stop)
+	<-l.done
+	l.stop, l.done = nil, nil
+
+	err := repository.ReleaseLease(db.WithContext(ctx), l.name, l.owner)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	heartbeatErr := l.heartbeatErr
+	l.heartbeatErr = nil
+	return heartbeatErr
+}
+
+func (l *TableLeaseLocker) startHeartbeat(db *gorm.DB) {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+
+	l.mu.Lock()
+	l.lost = make(chan struct{})
+	l.mu.Unlock()
+
+	go func(stop <-chan struct{}, done, lost chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(l.heartbeatInterval)
+		defer ticker.Stop()
+
+		expiresAt := time.Now().Add(l.leaseDuration)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			renewedAt := time.Now()
+			renewed, err := repository.RenewLease(db, l.name, l.owner, l.leaseDuration)
+			if err == nil && renewed {
+				expiresAt = renewedAt.Add(l.leaseDuration)
+				continue
+			}
+
+			if err == nil {
+				err = fmt.Errorf("lease %s was taken over by another owner", l.name)
+			} else if time.Now().Before(expiresAt) {
+				// ошибка продления не означает потерю аренды, пока не истек ее срок, продление повторяется
+				continue
+			} else {
+				err = fmt.Errorf("lease %s expired: %w", l.name, err)
+			}
+
+			l.mu.Lock()
+			l.heartbeatErr = err
+			l.mu.Unlock()
+
+			close(lost)
+			return
+		}
+	}(l.stop, l.done, l.lost)
+}
+
+func (l *TableLeaseLocker) wrapWaitError(ctx context.Context, db *gorm.DB, err error) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+
+	lease, getErr := repository.GetLease(db, l.name)
+	if getErr != nil {
+		return fmt.Errorf("%w: lease %s, waited %s", ErrLockTimeout, l.name, l.waitTimeout)
+	}
+	return fmt.Errorf(
+		"%w: lease %s is held by %s (host: %s) since %s, expires at %s",
+		ErrLockTimeout, l.name, lease.Owner, lease.Hostname,
+		lease.AcquiredAt.Format(time.RFC3339), lease.ExpiresAt.Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,37 @@
+package go_migrator
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewTableLeaseLocker(t *testing.T) {
+	tests := []struct {
+		name          string
+		leaseDuration time.Duration
+		err           error
+		heartbeat     time.Duration
+	}{
+		{name: "zero duration", leaseDuration: 0, err: ErrInvalidLeaseDuration},
+		{name: "negative duration", leaseDuration: -time.Second, err: ErrInvalidLeaseDuration},
+		{name: "too short for heartbeat", leaseDuration: 2 * time.Nanosecond, err: ErrInvalidLeaseDuration},
+		{name: "shortest valid duration", leaseDuration: 3 * time.Nanosecond, heartbeat: time.Nanosecond},
+		{name: "regular duration", leaseDuration: 30 * time.Second, heartbeat: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locker, err := NewTableLeaseLocker(tt.leaseDuration, time.Minute)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if locker.heartbeatInterval != tt.heartbeat {
+				t.Fatalf("expected heartbeat interval %s, got %s", tt.heartbeat, locker.heartbeatInterval)
+			}
+		})
+	}
+}
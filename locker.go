@@ -0,0 +1,155 @@
+package go_migrator
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"os"
+	"time"
+)
+
+var (
+	ErrLockTimeout = errors.New("timed out waiting for migrations lock")
+	ErrLockLost    = errors.New("migrations lock was lost")
+)
+
+// DefaultAdvisoryLockKey - ключ advisory lock, используемый PostgresAdvisoryLocker по умолчанию.
+var DefaultAdvisoryLockKey = getAdvisoryLockKey("go-migrator")
+
+// Locker обеспечивает взаимное исключение запусков Migrate и Downgrade между несколькими экземплярами приложения.
+// Блокировка захватывается до создания системных таблиц и удерживается до завершения выполнения плана.
+type Locker interface {
+	// Lock захватывает блокировку, ожидая ее освобождения другим владельцем.
+	Lock(ctx context.Context, db *gorm.DB) error
+	// Unlock освобождает захваченную ранее блокировку.
+	Unlock(ctx context.Context, db *gorm.DB) error
+}
+
+// LeaseLocker - Locker, блокировка которого может быть потеряна до вызова Unlock, например при истечении аренды.
+// При потере блокировки выполнение Migrate и Downgrade прерывается отменой контекста.
+type LeaseLocker interface {
+	Locker
+	// Lost возвращает канал, который закрывается при потере блокировки, захваченной последним вызовом Lock.
+	Lost() <-chan struct{}
+}
+
+// NewPostgresAdvisoryLocker создает Locker на основе pg_advisory_lock.
+// key - ключ блокировки, общий для всех экземпляров приложения, работающих с одной базой данных.
+// waitTimeout - максимальное время ожидания блокировки, при нулевом значении ожидание не ограничено.
+func NewPostgresAdvisoryLocker(key int64, waitTimeout time.Duration) *PostgresAdvisoryLocker {
+	return &PostgresAdvisoryLocker{
+		key:          key,
+		waitTimeout:  waitTimeout,
+		pollInterval: time.Second,
+	}
+}
+
+// PostgresAdvisoryLocker удерживает сессионный advisory lock на выделенном соединении, т.к. блокировка принадлежит
+// сессии, а не транзакции, и должна освобождаться тем же соединением, которым была захвачена.
+type PostgresAdvisoryLocker struct {
+	key          int64
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+
+	conn *sql.Conn
+}
+
+func (l *PostgresAdvisoryLocker) Lock(ctx context.Context, db *gorm.DB) error {
+	if l.conn != nil {
+		return errors.New("advisory lock is already held")
+	}
+
+	if l.waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.waitTimeout)
+		defer cancel()
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return l.wrapWaitError(ctx, err)
+	}
+	session := pinConnection(ctx, db, conn)
+
+	for {
+		acquired, err := repository.TryAdvisoryLock(session, l.key)
+		if err != nil {
+			_ = conn.Close()
+			return l.wrapWaitError(ctx, err)
+		}
+		if acquired {
+			l.conn = conn
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+			return l.wrapWaitError(ctx, ctx.Err())
+		case <-time.After(l.pollInterval):
+		}
+	}
+}
+
+func (l *PostgresAdvisoryLocker) Unlock(ctx context.Context, db *gorm.DB) error {
+	if l.conn == nil {
+		return nil
+	}
+
+	conn := l.conn
+	l.conn = nil
+	// закрытие соединения завершает сессию, что освобождает блокировку даже при ошибке pg_advisory_unlock
+	defer conn.Close()
+
+	released, err := repository.AdvisoryUnlock(pinConnection(ctx, db, conn), l.key)
+	if err != nil {
+		return err
+	}
+	if !released {
+		return fmt.Errorf("advisory lock %d was not held by current session", l.key)
+	}
+	return nil
+}
+
+func (l *PostgresAdvisoryLocker) wrapWaitError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: advisory lock %d, waited %s", ErrLockTimeout, l.key, l.waitTimeout)
+	}
+	return err
+}
+
+// pinConnection возвращает сессию gorm, выполняющую все запросы на переданном соединении.
+func pinConnection(ctx context.Context, db *gorm.DB, conn *sql.Conn) *gorm.DB {
+	session := db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	session.Statement.ConnPool = conn
+	return session
+}
+
+func getAdvisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	// fnv.sum64a always writes with no error
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// newOwnerID возвращает идентификатор текущего процесса, уникальный между экземплярами приложения.
+func newOwnerID() string {
+	hostname, _ := os.Hostname()
+
+	suffix := make([]byte, 4)
+	// crypto/rand.Read fails only on broken systems, suffix stays zeroed then
+	_, _ = rand.Read(suffix)
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
@@ -1,6 +1,10 @@
 package go_migrator
 
-import "io"
+import (
+	"gorm.io/gorm"
+	"io"
+	"time"
+)
 
 type ManagerOption func(*MigrationManager)
 
@@ -15,3 +19,144 @@ func WithLogFlags(flags int) ManagerOption {
 		m.logger.SetFlags(flags)
 	}
 }
+
+// WithLocker задает блокировку, удерживаемую на время выполнения Migrate и Downgrade. Позволяет безопасно запускать
+// миграции одновременно из нескольких экземпляров приложения.
+func WithLocker(locker Locker) ManagerOption {
+	return func(m *MigrationManager) {
+		m.locker = locker
+	}
+}
+
+// WithGroupTransaction позволяет выполнить весь план Migrate или Downgrade вместе с обновлением таблиц migrations и
+// version в одной транзакции. При ошибке любой из миграций откатывается весь запуск, и база данных остается в
+// состоянии до его начала. Миграции, зарегистрированные с WithTransaction(false), в этом режиме не допускаются.
+func WithGroupTransaction() ManagerOption {
+	return func(m *MigrationManager) {
+		m.groupTransaction = true
+	}
+}
+
+// WithRecoveryPolicy задает политику обработки миграций, выполнение которых было прервано аварийным завершением
+// процесса. По умолчанию используется RecoveryFail. Без Locker политика применяется только к миграциям, запущенным
+// раньше, чем WithRecoveryStaleAfter назад (см. ErrMigrationsInProgress).
+func WithRecoveryPolicy(policy RecoveryPolicy) ManagerOption {
+	return func(m *MigrationManager) {
+		m.recoveryPolicy = policy
+	}
+}
+
+// WithRecoveryStaleAfter задает время, по истечении которого после запуска миграция в состоянии
+// models.StateRunning считается прерванной, если Locker не задан. Значение должно превышать время выполнения самой
+// долгой миграции, иначе миграция, выполняемая другим экземпляром приложения, будет восстановлена.
+func WithRecoveryStaleAfter(staleAfter time.Duration) ManagerOption {
+	return func(m *MigrationManager) {
+		m.recoveryStaleAfter = staleAfter
+	}
+}
+
+// WithRollbackOnFailure позволяет при ошибке миграции откатить миграции типа TypeVersioned, успешно выполненные
+// в том же запуске Migrate, в обратном порядке и восстановить сохраненную версию, предшествовавшую запуску.
+// Запуск завершается ошибкой *CompensationError, содержащей исходную ошибку и ошибки отката. При отмене контекста и
+// в групповой транзакции (см. WithGroupTransaction) откат не выполняется. Откат останавливается на необратимой
+// миграции (см. WithIrreversible), ее версия остается сохраненной.
+func WithRollbackOnFailure() ManagerOption {
+	return func(m *MigrationManager) {
+		m.rollbackOnFailure = true
+	}
+}
+
+// WithReapplyUndone позволяет Migrate повторно выполнять миграции типа TypeVersioned, откаченные с помощью
+// UndoMigration (models.StateReverted). Без этой опции такие миграции остаются откаченными.
+func WithReapplyUndone() ManagerOption {
+	return func(m *MigrationManager) {
+		m.reapplyUndone = true
+	}
+}
+
+// WithRetryPolicy задает политику повторов транзакционных миграций, завершившихся временной ошибкой базы данных
+// (см. DefaultRetryPolicy и IsTransientError). Количество попыток сохраняется вместе с состоянием миграции.
+func WithRetryPolicy(policy RetryPolicy) ManagerOption {
+	return func(m *MigrationManager) {
+		m.retryPolicy = &policy
+	}
+}
+
+// WithExecutionSettings задает параметры выполнения миграций по умолчанию. Параметры, заданные для миграции
+// с помощью WithIsolationLevel, WithLockTimeout и WithStatementTimeout, имеют приоритет. При выполнении в групповой
+// транзакции уровень изоляции применяется ко всей группе.
+func WithExecutionSettings(settings ExecutionSettings) ManagerOption {
+	return func(m *MigrationManager) {
+		m.executionSettings = settings
+	}
+}
+
+// WithConnection регистрирует дополнительное соединение под именем name. Миграции, привязанные к соединению с помощью
+// WithMigrationConnection, выполняются на нем, а таблицы migrations и version по-прежнему обновляются через основное
+// соединение управляющего.
+func WithConnection(name string, db *gorm.DB) ManagerOption {
+	return func(m *MigrationManager) {
+		m.connections[name] = db
+	}
+}
+
+// WithPrimaryResolver задает функцию, направляющую запросы миграций на основной сервер при использовании реплик,
+// например, для gorm.io/plugin/dbresolver:
+//
+//	WithPrimaryResolver(func(db *gorm.DB) *gorm.DB { return db.Clauses(dbresolver.Write) })
+//
+// Функция применяется к соединению каждой миграции, в том числе к соединениям, заданным с помощью WithConnection,
+// а также к основному соединению при чтении и сохранении состояния миграций, захвате блокировки, проверках окружения
+// и построении планов.
+func WithPrimaryResolver(resolve func(db *gorm.DB) *gorm.DB) ManagerOption {
+	return func(m *MigrationManager) {
+		m.primaryResolver = resolve
+	}
+}
+
+// WithSessionInit задает SQL-запросы, выполняемые в начале транзакции каждой миграции и перед каждой
+// нетранзакционной миграцией, например, SET ROLE, SET search_path или SET application_name. При групповой транзакции
+// запросы выполняются один раз при ее открытии и действуют в том числе на сохранение состояния миграций.
+// Миграции выполняются на выделенных соединениях, которые закрываются после выполнения, чтобы параметры сессии
+// не достались другим запросам приложения.
+func WithSessionInit(statements ...string) ManagerOption {
+	return func(m *MigrationManager) {
+		for _, statement := range statements {
+			statement := statement
+			m.sessionInit = append(m.sessionInit, func(db *gorm.DB) error {
+				return db.Exec(statement).Error
+			})
+		}
+	}
+}
+
+// WithSessionInitFunc задает функцию инициализации сессии, выполняемую так же, как запросы WithSessionInit.
+func WithSessionInitFunc(fn func(*gorm.DB) error) ManagerOption {
+	return func(m *MigrationManager) {
+		m.sessionInit = append(m.sessionInit, fn)
+	}
+}
+
+// WithPreflightChecks задает проверки окружения, выполняемые перед Migrate и Downgrade до создания системных таблиц
+// и в CheckFulfillment. Если хотя бы одна проверка не пройдена, запуск прерывается с ошибкой *PreflightError.
+func WithPreflightChecks(checks ...PreflightCheck) ManagerOption {
+	return func(m *MigrationManager) {
+		m.preflightChecks = append(m.preflightChecks, checks...)
+	}
+}
+
+// WithPlanExplanation выводит в лог решение планировщика по каждой сохраненной миграции: попала ли она в план и по
+// какому правилу. Решения также доступны в Plan.Decisions независимо от этой опции.
+func WithPlanExplanation() ManagerOption {
+	return func(m *MigrationManager) {
+		m.explainPlan = true
+	}
+}
+
+// WithEnvironment задает окружение, в котором работает управляющий. По умолчанию используется
+// EnvironmentDevelopment, в EnvironmentProduction операции разработки, например Redo, запрещены.
+func WithEnvironment(environment Environment) ManagerOption {
+	return func(m *MigrationManager) {
+		m.environment = environment
+	}
+}
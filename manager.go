@@ -1,6 +1,7 @@
 package go_migrator
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/MashinIvan/go-migrator/internal/models"
@@ -9,6 +10,8 @@ import (
 	"hash/fnv"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 var (
@@ -16,22 +19,32 @@ var (
 	ErrHasForthcomingMigrations = errors.New("found not completed forthcoming migrations, consider migrating")
 	ErrHasFailedMigrations      = errors.New("found failed migrations, consider fixing your db")
 	ErrTargetVersionNotLatest   = errors.New("target version falls behind migrations, consider raising target version")
+	ErrNonTransactionalInGroup  = errors.New("migrations without transaction cannot run inside group transaction")
+	ErrConnectionInGroup        = errors.New("migrations with own connection cannot run inside group transaction")
 )
 
 // NewMigrationsManager создает экземпляр управляющего миграциями (выступает в качестве фасада).
 // targetVersion - версия, до которой необходимо выполнить миграцию или до которой необоходимо осуществить откат.
+// Пустая строка означает последнюю версию зарегистрированных миграций (см. MigrateLatest), при этом Downgrade
+// требует явной версии (см. DowngradeTo).
 func NewMigrationsManager(db *gorm.DB, targetVersion string, opts ...ManagerOption) (*MigrationManager, error) {
-	target, err := parseVersion(targetVersion)
-	if err != nil {
-		return nil, err
+	if targetVersion != "" {
+		_, err := parseVersion(targetVersion)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	manager := MigrationManager{
 		db:                      db,
 		logger:                  log.New(os.Stderr, "", log.LstdFlags),
-		targetVersion:           target,
+		owner:                   newOwnerID(),
+		recoveryPolicy:          RecoveryFail,
+		environment:             EnvironmentDevelopment,
+		targetVersion:           targetVersion,
 		registeredMigrations:    make([]*Migration, 0),
 		registeredMigrationsSet: make(map[uint32]*Migration),
+		connections:             make(map[string]*gorm.DB),
 	}
 	for _, opt := range opts {
 		opt(&manager)
@@ -43,40 +56,97 @@ func NewMigrationsManager(db *gorm.DB, targetVersion string, opts ...ManagerOpti
 type MigrationManager struct {
 	db     *gorm.DB
 	logger *log.Logger
-
-	targetVersion Version
+	locker Locker
+
+	groupTransaction  bool
+	rollbackOnFailure bool
+	reapplyUndone     bool
+
+	// owner идентифицирует текущий процесс в миграциях, находящихся в состоянии models.StateRunning
+	owner          string
+	recoveryPolicy RecoveryPolicy
+	// recoveryStaleAfter - время, после которого миграция в состоянии models.StateRunning считается прерванной без
+	// Locker, нулевое значение означает, что без Locker миграции не восстанавливаются
+	recoveryStaleAfter time.Duration
+	retryPolicy        *RetryPolicy
+
+	executionSettings ExecutionSettings
+	connections       map[string]*gorm.DB
+	primaryResolver   func(*gorm.DB) *gorm.DB
+	sessionInit       []func(*gorm.DB) error
+	preflightChecks   []PreflightCheck
+	explainPlan       bool
+	environment       Environment
+
+	// targetVersion - target версия по умолчанию, пустая строка означает последнюю зарегистрированную версию
+	targetVersion string
 
 	registeredMigrations    []*Migration
 	registeredMigrationsSet map[uint32]*Migration
+	registrationErrors      []error
 }
 
 // RegisterMigration сохраняет миграции в память.
 // По умолчанию миграции осуществляются внутри транзакции.
 //
-// Паникует при регистрации миграций с одинаковымм версией и типом.
-func (m *MigrationManager) RegisterMigration(migration *Migration, opts ...MigrationOption) {
+// Возвращает *DuplicateMigrationError при регистрации миграций с одинаковыми версией и типом и
+// ErrConnectionNotFound, если соединение миграции не зарегистрировано с помощью WithConnection. Ошибки регистрации
+// также запоминаются и возвращаются следующим вызовом Migrate или Downgrade, поэтому пропущенная ошибка
+// не приведет к выполнению неполного набора миграций.
+func (m *MigrationManager) RegisterMigration(migration *Migration, opts ...MigrationOption) error {
 	for _, opt := range opts {
 		opt(migration)
 	}
 
 	identifier := getMigrationIdentifier(migration.version, string(migration.migrationType))
 	if _, ok := m.registeredMigrationsSet[identifier]; ok {
-		panic(fmt.Sprintf(
-			"Migration with same identifier twice. Type: %s. Identifier: %d",
-			migration.migrationType, identifier,
-		))
+		err := &DuplicateMigrationError{
+			Type:       migration.migrationType,
+			Version:    migration.version,
+			Identifier: identifier,
+		}
+		m.registrationErrors = append(m.registrationErrors, err)
+		return err
+	}
+
+	if _, ok := m.connections[migration.connection]; migration.connection != "" && !ok {
+		err := fmt.Errorf(
+			"%w: %s (type: %s, version: %s)",
+			ErrConnectionNotFound, migration.connection, migration.migrationType, migration.version,
+		)
+		m.registrationErrors = append(m.registrationErrors, err)
+		return err
 	}
 
 	migration.identifier = identifier
 	m.registeredMigrationsSet[identifier] = migration
 	m.registeredMigrations = append(m.registeredMigrations, migration)
-	return
+	return nil
 }
 
-// CheckFulfillment проверяет корректность установки всех миграций. Проверяется, что нет миграций со статусом
-// models.StateFailure, затем проверяется, что все зарегистрированные миграции выше послденей сохраненной версии сохранены и
-// выполнены успешно, затем проверяется, что target версия установлена выше или равной последней найденной миграции.
+// CheckFulfillment проверяет корректность установки всех миграций. Проверяется, что пройдены проверки окружения
+// WithPreflightChecks (причина - *PreflightError), что нет миграций, прерванных в состоянии models.StateRunning,
+// и миграций со статусом models.StateFailure, затем проверяется, что все зарегистрированные миграции выше последней
+// сохраненной версии сохранены и выполнены успешно, затем проверяется, что target версия установлена выше или равной
+// последней найденной миграции.
 func (m *MigrationManager) CheckFulfillment() (reasonErr error, ok bool, err error) {
+	_, err = m.RunPreflightChecks(context.Background())
+	if err != nil {
+		var preflightErr *PreflightError
+		if errors.As(err, &preflightErr) {
+			return preflightErr, false, nil
+		}
+		return nil, false, err
+	}
+
+	hasInterrupted, err := m.HasInterruptedMigrations()
+	if err != nil {
+		return nil, false, err
+	}
+	if hasInterrupted {
+		return ErrHasInterruptedMigrations, false, nil
+	}
+
 	hasForthcoming, err := m.HasForthcomingMigrations()
 	if err != nil {
 		return nil, false, err
@@ -104,14 +174,15 @@ func (m *MigrationManager) CheckFulfillment() (reasonErr error, ok bool, err err
 	return nil, true, nil
 }
 
-// HasFailedMigrations определяет есть ли миграции, не выполненные из-за ошибки.
+// HasFailedMigrations определяет есть ли миграции, не выполненные из-за ошибки. Миграции в состоянии
+// models.StateFailedIgnored не учитываются.
 func (m *MigrationManager) HasFailedMigrations() (bool, error) {
 	// не было выполнено ни одной, следовательно пока ошибок не было
 	if !repository.HasVersionTable(m.db) || !repository.HasMigrationsTable(m.db) {
 		return false, nil
 	}
 
-	savedMigrations, err := repository.GetMigrationsSorted(m.db, repository.OrderASC)
+	savedMigrations, err := m.loadSavedMigrations(m.db, repository.OrderASC)
 	if err != nil {
 		return false, err
 	}
@@ -132,9 +203,12 @@ func (m *MigrationManager) HasForthcomingMigrations() (bool, error) {
 		return true, nil
 	}
 
-	savedVersion := m.getSavedAppVersion()
+	savedVersion, err := m.getSavedAppVersion(m.db)
+	if err != nil {
+		return false, err
+	}
 
-	savedMigrations, err := repository.GetMigrationsSorted(m.db, repository.OrderASC)
+	savedMigrations, err := m.loadSavedMigrations(m.db, repository.OrderASC)
 	if err != nil {
 		return false, err
 	}
@@ -157,6 +231,18 @@ func (m *MigrationManager) HasForthcomingMigrations() (bool, error) {
 			continue
 		}
 
+		// миграции, которым разрешено завершаться с ошибкой, не считаются невыполненными
+		if savedMigrations[i].State == models.StateFailedIgnored {
+			continue
+		}
+		// миграции, откаченные UndoMigration, выполняются Migrate только при WithReapplyUndone
+		if savedMigrations[i].State == models.StateReverted {
+			if m.reapplyUndone {
+				return true, nil
+			}
+			continue
+		}
+
 		migrationVersion := mustParseVersion(savedMigrations[i].Version)
 		if migrationVersion.MoreOrEqual(savedVersion) && savedMigrations[i].State != models.StateSuccess {
 			return true, nil
@@ -174,29 +260,37 @@ func (m *MigrationManager) HasForthcomingMigrations() (bool, error) {
 	return false, nil
 }
 
-// TargetVersionNotLatest проверяет, является ли target версия выше или равной максимальной версии зарегистрированной
-// или сохраненной миграции.
+// TargetVersionNotLatest проверяет, является ли target версия по умолчанию выше или равной максимальной версии
+// зарегистрированной или сохраненной миграции.
 func (m *MigrationManager) TargetVersionNotLatest() (bool, error) {
+	targetVersion, err := m.resolveTargetVersion(m.targetVersion)
+	if err != nil {
+		return false, err
+	}
+	return m.targetVersionNotLatest(targetVersion)
+}
+
+func (m *MigrationManager) targetVersionNotLatest(targetVersion Version) (bool, error) {
 	// не было выполнено ни одной, следовательно пока ошибок не было
 	if !repository.HasVersionTable(m.db) || !repository.HasMigrationsTable(m.db) {
 		return false, nil
 	}
 
-	savedMigrations, err := repository.GetMigrationsSorted(m.db, repository.OrderASC)
+	savedMigrations, err := m.loadSavedMigrations(m.db, repository.OrderASC)
 	if err != nil {
 		return false, err
 	}
 
 	for i, _ := range savedMigrations {
 		migrationVersion := mustParseVersion(savedMigrations[i].Version)
-		if !m.targetVersion.MoreOrEqual(migrationVersion) {
+		if !targetVersion.MoreOrEqual(migrationVersion) {
 			return true, nil
 		}
 	}
 
 	for i, _ := range m.registeredMigrations {
 		migrationVersion := mustParseVersion(m.registeredMigrations[i].version)
-		if !m.targetVersion.MoreOrEqual(migrationVersion) {
+		if !targetVersion.MoreOrEqual(migrationVersion) {
 			return true, nil
 		}
 	}
@@ -204,6 +298,130 @@ func (m *MigrationManager) TargetVersionNotLatest() (bool, error) {
 	return false, nil
 }
 
+// resolveTargetVersion разбирает target версию. Пустая строка означает последнюю версию зарегистрированных миграций.
+func (m *MigrationManager) resolveTargetVersion(version string) (Version, error) {
+	if version == "" {
+		return m.latestVersion(), nil
+	}
+	return parseVersion(version)
+}
+
+// latestVersion возвращает максимальную версию зарегистрированных миграций.
+func (m *MigrationManager) latestVersion() Version {
+	latest := Version{}
+	for _, migration := range m.registeredMigrations {
+		migrationVersion := mustParseVersion(migration.version)
+		if migrationVersion.MoreThan(latest) {
+			latest = migrationVersion
+		}
+	}
+	return latest
+}
+
+// checkGroupTransaction проверяет, что при выполнении в групповой транзакции нет миграций, зарегистрированных
+// с WithTransaction(false) или выполняемых на отдельном соединении.
+func (m *MigrationManager) checkGroupTransaction() error {
+	if !m.groupTransaction {
+		return nil
+	}
+
+	rejected := make([]string, 0)
+	withConnection := make([]string, 0)
+	for _, migration := range m.registeredMigrations {
+		if !migration.transaction {
+			rejected = append(rejected, fmt.Sprintf("%s %s", migration.migrationType, migration.version))
+		}
+		if migration.connection != "" {
+			withConnection = append(withConnection, fmt.Sprintf(
+				"%s %s (connection: %s)", migration.migrationType, migration.version, migration.connection,
+			))
+		}
+	}
+	if len(rejected) != 0 {
+		return fmt.Errorf("%w: %s", ErrNonTransactionalInGroup, strings.Join(rejected, ", "))
+	}
+	if len(withConnection) != 0 {
+		return fmt.Errorf("%w: %s", ErrConnectionInGroup, strings.Join(withConnection, ", "))
+	}
+
+	return nil
+}
+
+// execute выполняет fn в групповой транзакции, если она включена, иначе - на db. stateDB - соединение, через которое
+// сохраняются состояния миграций: вне групповой транзакции оно не отменяется вместе с ctx, т.к. результат уже
+// выполненной миграции должен быть сохранен даже при отмене контекста.
+func (m *MigrationManager) execute(ctx context.Context, db *gorm.DB, fn func(db, stateDB *gorm.DB) error) error {
+	if !m.groupTransaction {
+		return fn(db, m.primaryDB(detachContext(ctx)))
+	}
+
+	return m.withSessionConnection(db, func(session *gorm.DB) error {
+		return session.Transaction(func(tx *gorm.DB) error {
+			err := m.initSession(tx)
+			if err != nil {
+				return err
+			}
+			return fn(tx, tx)
+		}, m.executionSettings.txOptions()...)
+	})
+}
+
+// withLock выполняет fn под блокировкой, если для управляющего задан Locker. Если блокировка реализует LeaseLocker и
+// теряется до завершения fn, контекст fn отменяется, а запуск завершается ошибкой ErrLockLost.
+func (m *MigrationManager) withLock(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if m.locker == nil {
+		return fn(ctx)
+	}
+
+	m.logger.Println("Acquiring migrations lock")
+	err = m.locker.Lock(ctx, m.forcePrimary(m.db))
+	if err != nil {
+		return err
+	}
+	m.logger.Println("Migrations lock acquired")
+
+	defer func() {
+		unlockErr := m.locker.Unlock(context.Background(), m.forcePrimary(m.db))
+		if unlockErr != nil {
+			m.logger.Println("Error occurred on releasing migrations lock:", unlockErr)
+			if err == nil {
+				err = unlockErr
+			}
+			return
+		}
+		m.logger.Println("Migrations lock released")
+	}()
+
+	leaseLocker, ok := m.locker.(LeaseLocker)
+	if !ok {
+		return fn(ctx)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lost := leaseLocker.Lost()
+	watchDone := make(chan struct{})
+	defer close(watchDone)
+	go func() {
+		select {
+		case <-lost:
+			m.logger.Println("Migrations lock lost, interrupting run")
+			cancel()
+		case <-watchDone:
+		}
+	}()
+
+	err = fn(runCtx)
+
+	select {
+	case <-lost:
+		return fmt.Errorf("%w, run interrupted: %v", ErrLockLost, err)
+	default:
+		return err
+	}
+}
+
 func (m *MigrationManager) findMigration(migrationModel models.MigrationModel) (*Migration, bool) {
 	migrationModelIdentifier := getMigrationIdentifier(migrationModel.Version, migrationModel.Type)
 
@@ -217,17 +435,68 @@ func (m *MigrationManager) findMigration(migrationModel models.MigrationModel) (
 	return nil, false
 }
 
-func (m *MigrationManager) getSavedAppVersion() Version {
-	savedAppVersion, err := repository.GetVersion(m.db)
+func (m *MigrationManager) getSavedAppVersion(db *gorm.DB) (Version, error) {
+	savedAppVersion, err := repository.GetVersion(db)
 	// если текущая версия миграции не найдена, возвращаем версию 0.0.0, как минимально возможную
 	if err == repository.ErrNotFound {
-		return Version{}
+		return Version{}, nil
+	}
+	if err != nil {
+		return Version{}, err
 	}
+
+	return parseVersion(savedAppVersion)
+}
+
+// loadSavedMigrations загружает сохраненные миграции и проверяет корректность их версий, после чего версии можно
+// разбирать с помощью mustParseVersion.
+func (m *MigrationManager) loadSavedMigrations(db *gorm.DB, order repository.Order) ([]models.MigrationModel, error) {
+	savedMigrations, err := repository.GetMigrationsSorted(db, order)
 	if err != nil {
-		return Version{}
+		return nil, err
+	}
+
+	for i, _ := range savedMigrations {
+		_, err = parseVersion(savedMigrations[i].Version)
+		if err != nil {
+			return nil, fmt.Errorf("saved migration (type: %s): %w", savedMigrations[i].Type, err)
+		}
+	}
+
+	return savedMigrations, nil
+}
+
+// checkRegistrationErrors возвращает первую ошибку, запомненную при регистрации миграций.
+func (m *MigrationManager) checkRegistrationErrors() error {
+	if len(m.registrationErrors) == 0 {
+		return nil
 	}
+	return m.registrationErrors[0]
+}
+
+// detachedContext сохраняет значения родительского контекста, но не наследует его отмену и дедлайн.
+type detachedContext struct {
+	parent context.Context
+}
+
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
 
-	return mustParseVersion(savedAppVersion)
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
 }
 
 func migrationIsNew(migration *Migration, savedMigrations []models.MigrationModel) bool {
@@ -1,5 +1,10 @@
 package go_migrator
 
+import (
+	"database/sql"
+	"time"
+)
+
 type MigrationOption func(*Migration)
 
 // WithTransaction позволяет выполнить текущую миграцию внутри транзации. По умолчанию равен true. При выполнении
@@ -11,6 +16,73 @@ func WithTransaction(useTransaction bool) MigrationOption {
 	}
 }
 
+// WithAllowFailure позволяет продолжить выполнение остальных миграций, если текущая миграция завершилась ошибкой.
+// Такая миграция сохраняется в состоянии models.StateFailedIgnored вместе с текстом ошибки, не учитывается
+// в HasFailedMigrations и не повышает сохраненную версию. Миграция типа TypeVersioned в этом состоянии не выполняется
+// повторно следующими запусками Migrate.
+func WithAllowFailure() MigrationOption {
+	return func(m *Migration) {
+		m.allowFailure = true
+	}
+}
+
+// WithIrreversible помечает миграцию типа TypeVersioned как необратимую. Downgrade, план которого включает
+// необратимую миграцию, завершается ошибкой *DowngradeBlockedError до отката каких-либо миграций. Откат при ошибке
+// (см. WithRollbackOnFailure) останавливается на необратимой миграции с ошибкой ErrIrreversibleMigration.
+func WithIrreversible() MigrationOption {
+	return func(m *Migration) {
+		m.irreversible = true
+	}
+}
+
+// WithMigrationRetryPolicy задает политику повторов для текущей миграции, переопределяя политику, заданную
+// в MigrationManager с помощью WithRetryPolicy. Применяется только к миграциям, выполняемым в собственной транзакции.
+func WithMigrationRetryPolicy(policy RetryPolicy) MigrationOption {
+	return func(m *Migration) {
+		m.retryPolicy = &policy
+	}
+}
+
+// WithIsolationLevel задает уровень изоляции транзакции текущей миграции, переопределяя значение, заданное
+// в MigrationManager с помощью WithExecutionSettings. sql.LevelDefault означает уровень изоляции по умолчанию базы
+// данных. Не применяется к миграциям без собственной транзакции.
+func WithIsolationLevel(level sql.IsolationLevel) MigrationOption {
+	return func(m *Migration) {
+		m.executionSettings.IsolationLevel = level
+		m.executionSettings.explicit |= settingIsolationLevel
+	}
+}
+
+// WithLockTimeout задает lock_timeout для текущей миграции, чтобы миграция, ожидающая блокировку, не задерживала
+// очередь других запросов к таблице. Переопределяет значение, заданное в MigrationManager с помощью
+// WithExecutionSettings. Нулевое значение отключает lock_timeout для текущей миграции.
+func WithLockTimeout(timeout time.Duration) MigrationOption {
+	return func(m *Migration) {
+		m.executionSettings.LockTimeout = timeout
+		m.executionSettings.explicit |= settingLockTimeout
+	}
+}
+
+// WithStatementTimeout задает statement_timeout для текущей миграции. Переопределяет значение, заданное
+// в MigrationManager с помощью WithExecutionSettings. Нулевое значение отключает statement_timeout для текущей
+// миграции.
+func WithStatementTimeout(timeout time.Duration) MigrationOption {
+	return func(m *Migration) {
+		m.executionSettings.StatementTimeout = timeout
+		m.executionSettings.explicit |= settingStatementTimeout
+	}
+}
+
+// WithMigrationConnection выполняет текущую миграцию на соединении, зарегистрированном в MigrationManager под
+// именем name с помощью WithConnection, например, на соединении с правами суперпользователя. Состояние миграции
+// сохраняется через основное соединение управляющего. Миграции с отдельным соединением не допускаются в групповой
+// транзакции.
+func WithMigrationConnection(name string) MigrationOption {
+	return func(m *Migration) {
+		m.connection = name
+	}
+}
+
 type RepeatableMigratorOption func(*Migration)
 
 // WithRepeatUnconditional позволяет игнорировать значение checksum для миграции типа TypeRepeatable и выполнять
@@ -67,6 +67,10 @@ type Migration struct {
 	transaction         bool
 	repeatUnconditional bool
 	allowFailure        bool
+	irreversible        bool
+	retryPolicy         *RetryPolicy
+	executionSettings   ExecutionSettings
+	connection          string
 
 	// свойства миграции
 	identifier    uint32
@@ -0,0 +1,330 @@
+package go_migrator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"sort"
+)
+
+var (
+	ErrPlanStale    = errors.New("database state changed since plan was computed")
+	ErrPlanMismatch = errors.New("registered migrations do not match plan")
+)
+
+// planFileFormat - версия формата файла плана.
+const planFileFormat = 1
+
+type planFile struct {
+	Format int   `json:"format"`
+	Plan   *Plan `json:"plan"`
+}
+
+// Write сериализует план в w.
+func (p *Plan) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(planFile{Format: planFileFormat, Plan: p})
+}
+
+// WriteFile сохраняет план в файл path для последующего выполнения с помощью ApplyPlan.
+func (p *Plan) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = p.Write(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadPlan читает план, сохраненный с помощью Plan.Write.
+func ReadPlan(r io.Reader) (*Plan, error) {
+	var content planFile
+	err := json.NewDecoder(r).Decode(&content)
+	if err != nil {
+		return nil, err
+	}
+
+	if content.Format != planFileFormat {
+		return nil, fmt.Errorf("unsupported plan file format %d", content.Format)
+	}
+	if content.Plan == nil {
+		return nil, errors.New("plan file contains no plan")
+	}
+	return content.Plan, nil
+}
+
+// ReadPlanFile читает план из файла, сохраненного с помощью Plan.WriteFile.
+func ReadPlanFile(path string) (*Plan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadPlan(file)
+}
+
+// ApplyPlan выполняет шаги плана, сохраненного в файл path с помощью Plan.WriteFile.
+func (m *MigrationManager) ApplyPlan(path string) error {
+	return m.ApplyPlanContext(context.Background(), path)
+}
+
+// ApplyPlanContext выполняет ровно те шаги, которые содержит сохраненный план, без повторного планирования. План
+// отклоняется с ошибкой ErrPlanStale, если состояние базы данных изменилось с момента его построения, и с ошибкой
+// ErrPlanMismatch, если зарегистрированные миграции не соответствуют плану: миграция шага не зарегистрирована или
+// изменилась ее контрольная сумма. Миграции типов TypeBaseline и TypeVersioned контрольной суммы не имеют, для них
+// проверяется только регистрация.
+func (m *MigrationManager) ApplyPlanContext(ctx context.Context, path string) error {
+	plan, err := ReadPlanFile(path)
+	if err != nil {
+		return err
+	}
+
+	return m.withLock(ctx, func(ctx context.Context) error {
+		return m.applyPlan(ctx, plan)
+	})
+}
+
+func (m *MigrationManager) applyPlan(ctx context.Context, plan *Plan) error {
+	m.logger.Printf("Preparing %s plan execution, fingerprint %s\n", plan.Phase, plan.Fingerprint)
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkGroupTransaction()
+	if err != nil {
+		return err
+	}
+
+	err = m.checkPreflight(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = m.verifyPlan(plan)
+	if err != nil {
+		return err
+	}
+
+	db := m.primaryDB(ctx)
+
+	// отпечаток проверяется до любых изменений базы данных
+	fingerprint, err := computeFingerprint(db)
+	if err != nil {
+		return err
+	}
+	if fingerprint != plan.Fingerprint {
+		return fmt.Errorf("%w: plan fingerprint %s, current fingerprint %s", ErrPlanStale, plan.Fingerprint, fingerprint)
+	}
+
+	switch plan.Phase {
+	case PhaseMigrate:
+		err = m.initSystemTables(db)
+	case PhaseDowngrade:
+		if !repository.HasVersionTable(db) || !repository.HasMigrationsTable(db) {
+			return ErrNoSystemTables
+		}
+		err = repository.UpgradeMigrationsTable(db)
+	default:
+		return fmt.Errorf("unknown plan phase %q", plan.Phase)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = m.recoverInterruptedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	if m.groupTransaction {
+		m.logger.Println("Executing plan inside group transaction")
+	}
+	err = m.execute(ctx, db, func(db, stateDB *gorm.DB) error {
+		if plan.Phase == PhaseDowngrade {
+			return m.executeSavedDowngradePlan(ctx, db, stateDB, plan)
+		}
+		return m.executeSavedMigratePlan(ctx, db, stateDB, plan)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logger.Println("Plan completed")
+	return nil
+}
+
+// verifyPlan проверяет target версию плана и соответствие зарегистрированных миграций шагам плана.
+func (m *MigrationManager) verifyPlan(plan *Plan) error {
+	_, err := parseVersion(plan.TargetVersion)
+	if err != nil {
+		return fmt.Errorf("plan target version: %w", err)
+	}
+
+	for _, step := range plan.Steps {
+		migration, ok := m.findMigration(models.MigrationModel{Type: string(step.Type), Version: step.Version})
+
+		switch step.Action {
+		case ActionExecute, ActionUndo:
+			if !ok {
+				return fmt.Errorf("%w: %s %s is not registered", ErrPlanMismatch, step.Type, step.Version)
+			}
+			if migration.checksum != step.Checksum {
+				return fmt.Errorf(
+					"%w: %s %s checksum changed from %q to %q",
+					ErrPlanMismatch, step.Type, step.Version, step.Checksum, migration.checksum,
+				)
+			}
+
+		case ActionMarkNotFound:
+			if ok {
+				return fmt.Errorf("%w: %s %s is registered now", ErrPlanMismatch, step.Type, step.Version)
+			}
+
+		case ActionSkip:
+			// пропуск выполняется вместе с миграцией типа TypeBaseline
+
+		default:
+			return fmt.Errorf("%w: unknown action %q", ErrPlanMismatch, step.Action)
+		}
+	}
+
+	return nil
+}
+
+func (m *MigrationManager) executeSavedMigratePlan(ctx context.Context, db, stateDB *gorm.DB, plan *Plan) error {
+	savedMigrations, err := m.saveNewMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	versionBefore, err := m.getSavedAppVersion(db)
+	if err != nil {
+		return err
+	}
+
+	// порядок, в котором сохраненные миграции помечаются пропущенными, совпадает с порядком после планирования
+	sort.SliceStable(savedMigrations, func(i, j int) bool {
+		leftVersioned := mustParseVersion(savedMigrations[i].Version)
+		rightVersioned := mustParseVersion(savedMigrations[j].Version)
+
+		return rightVersioned.MoreThan(leftVersioned)
+	})
+
+	migrationsPlan, err := restorePlan(plan, savedMigrations)
+	if err != nil {
+		return err
+	}
+
+	return m.runMigratePlan(ctx, db, stateDB, savedMigrations, versionBefore, migrationsPlan)
+}
+
+func (m *MigrationManager) executeSavedDowngradePlan(ctx context.Context, db, stateDB *gorm.DB, plan *Plan) error {
+	savedMigrations, err := m.loadSavedMigrations(db, repository.OrderDESC)
+	if err != nil {
+		return err
+	}
+
+	migrationsToPlan, err := m.saveNewMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	migrationsPlan, err := restorePlan(plan, migrationsToPlan)
+	if err != nil {
+		return err
+	}
+
+	// target версия проверена в verifyPlan
+	err = m.validateDowngradePlan(migrationsPlan, savedMigrations, mustParseVersion(plan.TargetVersion))
+	if err != nil {
+		return err
+	}
+
+	return m.runDowngradePlan(ctx, db, stateDB, savedMigrations, migrationsPlan)
+}
+
+// restorePlan восстанавливает план выполнения из шагов сохраненного плана. Шаги ActionSkip выполняются как следствие
+// выполнения миграции типа TypeBaseline и в план не попадают.
+func restorePlan(plan *Plan, savedMigrations []models.MigrationModel) (migrationsPlan, error) {
+	restored := newMigrationsPlan()
+
+	for _, step := range plan.Steps {
+		if step.Action == ActionSkip {
+			continue
+		}
+
+		found := false
+		for _, migrationModel := range savedMigrations {
+			if migrationModel.Type == string(step.Type) && migrationModel.Version == step.Version {
+				restored.migrationsToRun.PushBack(migrationModel)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return migrationsPlan{}, fmt.Errorf("%w: %s %s is not saved", ErrPlanStale, step.Type, step.Version)
+		}
+	}
+
+	return restored, nil
+}
+
+// computeFingerprint возвращает отпечаток состояния базы данных: сохраненной версии и строк таблицы migrations.
+func computeFingerprint(db *gorm.DB) (string, error) {
+	var savedVersion *string
+	if repository.HasVersionTable(db) {
+		version, err := repository.GetVersion(db)
+		if err != nil && err != repository.ErrNotFound {
+			return "", err
+		}
+		savedVersion = &version
+	}
+
+	savedMigrations := make([]models.MigrationModel, 0)
+	if repository.HasMigrationsTable(db) {
+		var err error
+		savedMigrations, err = repository.GetMigrationsSorted(db, repository.OrderASC)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fingerprint(savedVersion, savedMigrations), nil
+}
+
+// fingerprint возвращает отпечаток сохраненной версии и строк таблицы migrations. savedVersion равен nil, если
+// таблица version не создана.
+func fingerprint(savedVersion *string, savedMigrations []models.MigrationModel) string {
+	h := sha256.New()
+
+	if savedVersion != nil {
+		_, _ = fmt.Fprintf(h, "version %s\n", *savedVersion)
+	}
+
+	for _, migrationModel := range savedMigrations {
+		_, _ = fmt.Fprintf(
+			h, "migration %d %d %s %s %s %s\n",
+			migrationModel.Id, migrationModel.Rank, migrationModel.Type, migrationModel.Version,
+			migrationModel.Checksum, migrationModel.State,
+		)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
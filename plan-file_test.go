@@ -0,0 +1,99 @@
+package go_migrator
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	version := "1.1.0.0"
+	emptyVersion := ""
+	savedMigrations := func() []models.MigrationModel {
+		baseline := newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess)
+		baseline.Rank = 1
+		versioned := newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess)
+		versioned.Rank = 2
+		repeatable := newTestModel(TypeRepeatable, "1.9.0.0", models.StateSuccess)
+		repeatable.Rank = 3
+		repeatable.Checksum = "c1"
+		return []models.MigrationModel{baseline, versioned, repeatable}
+	}
+	expected := fingerprint(&version, savedMigrations())
+
+	tests := []struct {
+		name            string
+		savedVersion    *string
+		savedMigrations func() []models.MigrationModel
+		same            bool
+	}{
+		{
+			name:            "same state",
+			savedVersion:    &version,
+			savedMigrations: savedMigrations,
+			same:            true,
+		},
+		{
+			name:            "version table is not created",
+			savedMigrations: savedMigrations,
+		},
+		{
+			name:            "version is not saved",
+			savedVersion:    &emptyVersion,
+			savedMigrations: savedMigrations,
+		},
+		{
+			name:         "state changed",
+			savedVersion: &version,
+			savedMigrations: func() []models.MigrationModel {
+				migrations := savedMigrations()
+				migrations[1].State = models.StateUndone
+				return migrations
+			},
+		},
+		{
+			name:         "checksum changed",
+			savedVersion: &version,
+			savedMigrations: func() []models.MigrationModel {
+				migrations := savedMigrations()
+				migrations[2].Checksum = "c2"
+				return migrations
+			},
+		},
+		{
+			name:         "rank changed",
+			savedVersion: &version,
+			savedMigrations: func() []models.MigrationModel {
+				migrations := savedMigrations()
+				migrations[2].Rank = 4
+				return migrations
+			},
+		},
+		{
+			name:         "migration added",
+			savedVersion: &version,
+			savedMigrations: func() []models.MigrationModel {
+				return append(savedMigrations(), newTestModel(TypeVersioned, "1.2.0.0", models.StateRegistered))
+			},
+		},
+		{
+			name:         "columns outside fingerprint are ignored",
+			savedVersion: &version,
+			savedMigrations: func() []models.MigrationModel {
+				migrations := savedMigrations()
+				migrations[1].Description = "changed description"
+				migrations[1].ErrorMessage = "error"
+				return migrations
+			},
+			same: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := fingerprint(tt.savedVersion, tt.savedMigrations())
+			if (actual == expected) != tt.same {
+				t.Fatalf("expected same fingerprint: %v, got %s and %s", tt.same, expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,317 @@
+package go_migrator
+
+import (
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// PlanAction - действие, которое запуск совершит над миграцией.
+type PlanAction string
+
+const (
+	// ActionExecute - миграция будет выполнена.
+	ActionExecute PlanAction = "execute"
+	// ActionSkip - миграция будет помечена как models.StateSkipped после выполнения миграции типа TypeBaseline.
+	ActionSkip PlanAction = "skip"
+	// ActionMarkNotFound - незарегистрированная миграция типа TypeRepeatable будет помечена как models.StateNotFound.
+	ActionMarkNotFound PlanAction = "mark-not-found"
+	// ActionUndo - миграция будет откачена.
+	ActionUndo PlanAction = "undo"
+)
+
+// PlanStep - шаг плана.
+type PlanStep struct {
+	Type        MigrationType         `json:"type"`
+	Version     string                `json:"version"`
+	Description string                `json:"description"`
+	State       models.MigrationState `json:"state"`
+	Action      PlanAction            `json:"action"`
+	// Checksum - контрольная сумма зарегистрированной миграции типа TypeRepeatable на момент построения плана.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+func (s PlanStep) String() string {
+	return fmt.Sprintf("%s %s %s (%s): %s", s.Action, s.Type, s.Version, s.State, s.Description)
+}
+
+// Plan описывает шаги, которые выполнит Migrate или Downgrade, в порядке их выполнения. Decisions содержит решения
+// планировщика по всем сохраненным и новым миграциям (см. Explain). Fingerprint - отпечаток состояния базы данных,
+// по которому был построен план (см. ApplyPlan).
+type Plan struct {
+	Phase         MigrationPhase `json:"phase"`
+	SavedVersion  string         `json:"saved_version"`
+	TargetVersion string         `json:"target_version"`
+	Fingerprint   string         `json:"fingerprint"`
+	Steps         []PlanStep     `json:"steps"`
+	Decisions     []PlanDecision `json:"decisions"`
+}
+
+func (p *Plan) IsEmpty() bool {
+	return len(p.Steps) == 0
+}
+
+func (p *Plan) String() string {
+	header := fmt.Sprintf(
+		"%s plan: saved version %s, target version %s, fingerprint %s",
+		p.Phase, p.SavedVersion, p.TargetVersion, p.Fingerprint,
+	)
+	if p.IsEmpty() {
+		return header + ", nothing to do"
+	}
+
+	lines := make([]string, 0, len(p.Steps)+1)
+	lines = append(lines, header)
+	for _, step := range p.Steps {
+		lines = append(lines, "  "+step.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addStep добавляет шаг плана. migration - зарегистрированная миграция или nil, если миграция не зарегистрирована.
+func (p *Plan) addStep(migrationModel models.MigrationModel, migration *Migration, action PlanAction) {
+	step := PlanStep{
+		Type:        MigrationType(migrationModel.Type),
+		Version:     migrationModel.Version,
+		Description: migrationModel.Description,
+		State:       migrationModel.State,
+		Action:      action,
+	}
+	if migration != nil {
+		step.Checksum = migration.checksum
+	}
+	p.Steps = append(p.Steps, step)
+}
+
+// PlanMigrate возвращает план, который выполнил бы Migrate, не изменяя базу данных. Новые миграции учитываются так,
+// как их сохранил бы Migrate, прерванные миграции - в соответствии с политикой восстановления.
+func (m *MigrationManager) PlanMigrate() (*Plan, error) {
+	return m.PlanMigrateTo(m.targetVersion)
+}
+
+// PlanMigrateTo возвращает план, который выполнил бы MigrateTo(version), не изменяя базу данных.
+func (m *MigrationManager) PlanMigrateTo(version string) (*Plan, error) {
+	return m.previewMigrate(version, 0)
+}
+
+// PlanMigrateSteps возвращает план, который выполнил бы MigrateSteps(steps), не изменяя базу данных. Решения по
+// миграциям, не попавшим в план из-за ограничения, содержат причину ReasonStepLimit.
+func (m *MigrationManager) PlanMigrateSteps(steps int) (*Plan, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidStepCount, steps)
+	}
+	return m.previewMigrate(m.targetVersion, steps)
+}
+
+func (m *MigrationManager) previewMigrate(version string, steps int) (*Plan, error) {
+	// план строится по состоянию основного сервера, т.к. по нему проверяется отпечаток в ApplyPlan
+	db := m.forcePrimary(m.db)
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return nil, err
+	}
+
+	targetVersion, err := m.resolveTargetVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := computeFingerprint(db)
+	if err != nil {
+		return nil, err
+	}
+
+	savedMigrations, err := m.previewSavedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	savedVersion := Version{}
+	if repository.HasVersionTable(db) {
+		savedVersion, err = m.getSavedAppVersion(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	planner := migratePlanner{
+		manager:         m,
+		targetVersion:   targetVersion,
+		savedVersion:    savedVersion,
+		savedMigrations: savedMigrations,
+	}
+	migrationsPlan := planner.MakePlan()
+	if steps != 0 {
+		migrationsPlan = migrationsPlan.limitSteps(steps)
+	}
+
+	plan := &Plan{
+		Phase:         PhaseMigrate,
+		SavedVersion:  savedVersion.String(),
+		TargetVersion: targetVersion.String(),
+		Fingerprint:   fingerprint,
+		Steps:         make([]PlanStep, 0),
+		Decisions:     migrationsPlan.Decisions(),
+	}
+	for _, migrationModel := range migrationsPlan.Migrations() {
+		migration, ok := m.findMigration(migrationModel)
+		if !ok {
+			if !m.allowBypassNotFound(migrationModel) {
+				return nil, newMigrationNotFoundError(migrationModel, PhaseMigrate)
+			}
+
+			plan.addStep(migrationModel, nil, ActionMarkNotFound)
+			continue
+		}
+
+		plan.addStep(migrationModel, migration, ActionExecute)
+
+		// см. saveStateOnSuccessfulMigration
+		if migrationModel.Type != string(TypeBaseline) {
+			continue
+		}
+		for _, skippedModel := range savedMigrations {
+			if skippedModel.Id == migrationModel.Id {
+				break
+			}
+			if skippedModel.State != models.StateSkipped {
+				plan.addStep(skippedModel, nil, ActionSkip)
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// PlanDowngrade возвращает план, который выполнил бы Downgrade, не изменяя базу данных. Если план не может быть
+// выполнен целиком, возвращается *DowngradeBlockedError.
+func (m *MigrationManager) PlanDowngrade() (*Plan, error) {
+	return m.PlanDowngradeTo(m.targetVersion)
+}
+
+// PlanDowngradeTo возвращает план, который выполнил бы DowngradeTo(version), не изменяя базу данных.
+func (m *MigrationManager) PlanDowngradeTo(version string) (*Plan, error) {
+	targetVersion, err := m.resolveDowngradeTargetVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return m.previewDowngrade(targetVersion, 0)
+}
+
+// PlanDowngradeSteps возвращает план, который выполнил бы DowngradeSteps(steps), не изменяя базу данных. Target
+// версия плана - версия, которая будет сохранена после отката.
+func (m *MigrationManager) PlanDowngradeSteps(steps int) (*Plan, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidStepCount, steps)
+	}
+	return m.previewDowngrade(Version{}, steps)
+}
+
+func (m *MigrationManager) previewDowngrade(targetVersion Version, steps int) (*Plan, error) {
+	// план строится по состоянию основного сервера, т.к. по нему проверяется отпечаток в ApplyPlan
+	db := m.forcePrimary(m.db)
+
+	err := m.checkRegistrationErrors()
+	if err != nil {
+		return nil, err
+	}
+
+	if !repository.HasVersionTable(db) || !repository.HasMigrationsTable(db) {
+		return nil, ErrNoSystemTables
+	}
+
+	fingerprint, err := computeFingerprint(db)
+	if err != nil {
+		return nil, err
+	}
+
+	savedMigrations, err := m.previewSavedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	savedVersion, err := m.getSavedAppVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	migrationsPlan, err := m.planDowngrade(db, savedMigrations, targetVersion)
+	if err != nil {
+		return nil, err
+	}
+	if steps != 0 {
+		migrationsPlan, targetVersion, err = m.limitDowngradeSteps(db, migrationsPlan, savedMigrations, steps)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.validateDowngradePlan(migrationsPlan, savedMigrations, targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Phase:         PhaseDowngrade,
+		SavedVersion:  savedVersion.String(),
+		TargetVersion: targetVersion.String(),
+		Fingerprint:   fingerprint,
+		Steps:         make([]PlanStep, 0),
+		Decisions:     migrationsPlan.Decisions(),
+	}
+	for _, migrationModel := range migrationsPlan.Migrations() {
+		migration, _ := m.findMigration(migrationModel)
+		plan.addStep(migrationModel, migration, ActionUndo)
+	}
+
+	return plan, nil
+}
+
+// previewSavedMigrations возвращает сохраненные миграции вместе с новыми так, как их сохранил бы saveNewMigrations,
+// и с состояниями прерванных миграций, восстановленными политикой RecoveryRetry, не изменяя базу данных. При других
+// политиках прерванные миграции прерывают запуск, поэтому возвращается *InterruptedMigrationsError. Для миграций,
+// которые могут выполняться другим процессом (см. recoverInterruptedMigrations), возвращается ErrMigrationsInProgress.
+func (m *MigrationManager) previewSavedMigrations(db *gorm.DB) ([]models.MigrationModel, error) {
+	savedMigrations := make([]models.MigrationModel, 0)
+	if repository.HasMigrationsTable(db) {
+		var err error
+		savedMigrations, err = m.loadSavedMigrations(db, repository.OrderASC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	runningModels := make([]models.MigrationModel, 0)
+	for i, _ := range savedMigrations {
+		if savedMigrations[i].State == models.StateRunning {
+			runningModels = append(runningModels, savedMigrations[i])
+		}
+	}
+	// запуск проверяет прерванные миграции под блокировкой, поэтому план строится так, как если бы она была захвачена
+	interruptedModels, inProgressModels := m.splitRunningMigrations(runningModels, m.locker != nil, time.Now())
+	if len(inProgressModels) != 0 {
+		return nil, newMigrationsInProgressError(inProgressModels)
+	}
+	if len(interruptedModels) != 0 && m.recoveryPolicy != RecoveryRetry {
+		return nil, m.newInterruptedMigrationsError(interruptedModels)
+	}
+	for i, _ := range savedMigrations {
+		if savedMigrations[i].State == models.StateRunning {
+			savedMigrations[i].State = retryState(savedMigrations[i])
+		}
+	}
+
+	requests, err := m.newMigrationRequests(savedMigrations)
+	if err != nil {
+		return nil, err
+	}
+	for _, request := range requests {
+		savedMigrations = append(savedMigrations, repository.NewMigrationModel(request))
+	}
+
+	return savedMigrations, nil
+}
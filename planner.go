@@ -2,12 +2,20 @@ package go_migrator
 
 import (
 	"container/list"
+	"fmt"
 	"github.com/MashinIvan/go-migrator/internal/models"
+	"io"
+	"log"
 	"sort"
 )
 
+// silentLogger используется планировщиками, ход планирования которых не выводится в лог.
+var silentLogger = log.New(io.Discard, "", 0)
+
 type migrationsPlan struct {
 	migrationsToRun *list.List
+	// decisions - решения планировщика по каждой сохраненной миграции
+	decisions []PlanDecision
 }
 
 func newMigrationsPlan() migrationsPlan {
@@ -20,6 +28,91 @@ func (p migrationsPlan) IsEmpty() bool {
 	return p.migrationsToRun.Len() == 0
 }
 
+// Migrations возвращает миграции плана в порядке выполнения, не изменяя план.
+func (p migrationsPlan) Migrations() []models.MigrationModel {
+	migrations := make([]models.MigrationModel, 0, p.migrationsToRun.Len())
+	for element := p.migrationsToRun.Front(); element != nil; element = element.Next() {
+		migrations = append(migrations, element.Value.(models.MigrationModel))
+	}
+	return migrations
+}
+
+func (p migrationsPlan) Decisions() []PlanDecision {
+	return p.decisions
+}
+
+// limitSteps возвращает план из первых steps миграций типов TypeBaseline и TypeVersioned. Миграции типа
+// TypeRepeatable выполняются после остальных, поэтому остаются в плане, только если не отброшена ни одна миграция.
+func (p migrationsPlan) limitSteps(steps int) migrationsPlan {
+	limited := newMigrationsPlan()
+
+	cut := make(map[uint32]struct{})
+	counted := 0
+	for _, migrationModel := range p.Migrations() {
+		identifier := getMigrationIdentifier(migrationModel.Version, migrationModel.Type)
+
+		if migrationModel.Type == string(TypeRepeatable) {
+			if len(cut) != 0 {
+				cut[identifier] = struct{}{}
+				continue
+			}
+		} else {
+			if counted == steps {
+				cut[identifier] = struct{}{}
+				continue
+			}
+			counted++
+		}
+
+		limited.migrationsToRun.PushBack(migrationModel)
+	}
+
+	limited.decisions = p.excludeDecisions(cut, ReasonStepLimit, fmt.Sprintf("step limit %d", steps))
+	return limited
+}
+
+// filter возвращает план из миграций, удовлетворяющих keep. Решения по остальным миграциям плана заменяются
+// решениями с причиной reason.
+func (p migrationsPlan) filter(
+	keep func(migrationModel models.MigrationModel) bool,
+	reason DecisionReason,
+	details string,
+) migrationsPlan {
+	filtered := newMigrationsPlan()
+
+	cut := make(map[uint32]struct{})
+	for _, migrationModel := range p.Migrations() {
+		if !keep(migrationModel) {
+			cut[getMigrationIdentifier(migrationModel.Version, migrationModel.Type)] = struct{}{}
+			continue
+		}
+		filtered.migrationsToRun.PushBack(migrationModel)
+	}
+
+	filtered.decisions = p.excludeDecisions(cut, reason, details)
+	return filtered
+}
+
+// excludeDecisions возвращает решения плана, в которых миграции с идентификаторами из cut не попадают в план по
+// причине reason.
+func (p migrationsPlan) excludeDecisions(
+	cut map[uint32]struct{},
+	reason DecisionReason,
+	details string,
+) []PlanDecision {
+	decisions := make([]PlanDecision, 0, len(p.decisions))
+	for _, decision := range p.decisions {
+		identifier := getMigrationIdentifier(decision.Version, string(decision.Type))
+		if _, ok := cut[identifier]; ok {
+			decision.Planned = false
+			decision.Reason = reason
+			decision.Details = details
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
 func (p migrationsPlan) PopFirst() models.MigrationModel {
 	first := p.migrationsToRun.Front()
 	p.migrationsToRun.Remove(first)
@@ -28,37 +121,72 @@ func (p migrationsPlan) PopFirst() models.MigrationModel {
 
 type migratePlanner struct {
 	manager         *MigrationManager
+	targetVersion   Version
+	savedVersion    Version
 	savedMigrations []models.MigrationModel
+	// silent - ход планирования и решения не выводятся в лог, например, при построении Status
+	silent bool
 
 	plannedBaseline   models.MigrationModel
 	baselineIsPlanned bool
+
+	logger    *log.Logger
+	decisions decisionRecorder
 }
 
 func (p *migratePlanner) MakePlan() migrationsPlan {
+	p.logger = plannerLogger(p.manager, p.silent)
+	p.decisions = decisionRecorder{logger: p.logger, log: p.manager.explainPlan}
+
 	plan := newMigrationsPlan()
 	p.planMigrationsBaseline(&plan)
 	p.planMigrationsVersioned(&plan)
 	p.planMigrationsRepeatable(&plan)
 
+	plan.decisions = p.decisions.decisions
 	return plan
 }
 
 func (p *migratePlanner) planMigrationsBaseline(plan *migrationsPlan) {
 	if !p.baselineRequired() {
+		for _, migrationModel := range p.savedMigrations {
+			if migrationModel.Type != string(TypeBaseline) {
+				continue
+			}
+			if migrationModel.State == models.StateSuccess {
+				p.decisions.skip(migrationModel, ReasonAlreadySuccessful, "")
+				continue
+			}
+			p.decisions.skip(migrationModel, ReasonBaselineNotRequired, "successful baseline migration exists")
+		}
 		return
 	}
-	p.manager.logger.Println("No successful baseline migrations found, planning to execute latest available")
+	p.logger.Println("No successful baseline migrations found, planning to execute latest available")
 
 	relevantBaseline, ok := p.findRelevantBaseline()
 	if !ok {
-		p.manager.logger.Println("No relevant baseline migrations for current target version found")
-		return
+		p.logger.Println("No relevant baseline migrations for current target version found")
+	} else {
+		plan.migrationsToRun.PushFront(relevantBaseline)
+
+		p.baselineIsPlanned = true
+		p.plannedBaseline = relevantBaseline
 	}
 
-	plan.migrationsToRun.PushFront(relevantBaseline)
+	for _, migrationModel := range p.savedMigrations {
+		if migrationModel.Type != string(TypeBaseline) {
+			continue
+		}
 
-	p.baselineIsPlanned = true
-	p.plannedBaseline = relevantBaseline
+		switch {
+		case p.baselineIsPlanned && migrationModel.Id == relevantBaseline.Id:
+			p.decisions.plan(migrationModel, ReasonLatestBaseline, "latest baseline migration not above target version")
+		case mustParseVersion(migrationModel.Version).MoreThan(p.targetVersion):
+			p.decisions.skip(migrationModel, ReasonAboveTarget, "target version "+p.targetVersion.String())
+		default:
+			p.decisions.skip(migrationModel, ReasonShadowedByBaseline, "baseline "+relevantBaseline.Version)
+		}
+	}
 }
 
 func (p *migratePlanner) planMigrationsVersioned(plan *migrationsPlan) {
@@ -74,28 +202,48 @@ func (p *migratePlanner) planMigrationsVersioned(plan *migrationsPlan) {
 			continue
 		}
 		if migrationModel.State == models.StateSuccess {
+			p.decisions.skip(migrationModel, ReasonAlreadySuccessful, "")
 			continue
 		}
 		if migrationModel.State == models.StateSkipped {
+			p.decisions.skip(migrationModel, ReasonSkipped, "")
+			continue
+		}
+		if migrationModel.State == models.StateFailedIgnored {
+			p.decisions.skip(migrationModel, ReasonFailedIgnored, "see WithAllowFailure")
 			continue
 		}
 
 		migrationVersion := mustParseVersion(migrationModel.Version)
 
-		if migrationVersion.MoreThan(p.manager.targetVersion) {
+		if migrationVersion.MoreThan(p.targetVersion) {
+			p.decisions.skip(migrationModel, ReasonAboveTarget, "target version "+p.targetVersion.String())
 			continue
 		}
-		if migrationVersion.LessOrEqual(p.manager.getSavedAppVersion()) {
+		if migrationModel.State == models.StateReverted {
+			if p.manager.reapplyUndone {
+				p.decisions.plan(migrationModel, ReasonReapplyUndone, "")
+				plan.migrationsToRun.PushBack(migrationModel)
+				continue
+			}
+
+			p.decisions.skip(migrationModel, ReasonReverted, "see WithReapplyUndone")
+			continue
+		}
+		if migrationVersion.LessOrEqual(p.savedVersion) {
+			p.decisions.skip(migrationModel, ReasonBelowSavedVersion, "saved version "+p.savedVersion.String())
 			continue
 		}
 
 		if p.baselineIsPlanned {
 			baselineVersion := mustParseVersion(p.plannedBaseline.Version)
 			if baselineVersion.MoreThan(migrationVersion) {
+				p.decisions.skip(migrationModel, ReasonShadowedByBaseline, "baseline "+p.plannedBaseline.Version)
 				continue
 			}
 		}
 
+		p.decisions.plan(migrationModel, ReasonPending, "")
 		plan.migrationsToRun.PushBack(migrationModel)
 	}
 }
@@ -116,18 +264,27 @@ func (p *migratePlanner) planMigrationsRepeatable(plan *migrationsPlan) {
 		migration, ok := p.manager.findMigration(migrationModel)
 		if !ok {
 			// добавляем в очередь, чтобы при выполнении проставить необходимые статусы
+			p.decisions.plan(migrationModel, ReasonNotFound, "migration is not registered")
 			plan.migrationsToRun.PushBack(migrationModel)
 			continue
 		}
 
 		if !migration.repeatUnconditional && migrationModel.Checksum == migration.checksum {
-			p.manager.logger.Printf(
+			p.logger.Printf(
 				"migration (type: %s, version: %s, checksum: %s) checksum not changed, skipping\n",
 				migrationModel.Type, migrationModel.Version, migrationModel.Checksum,
 			)
+			p.decisions.skip(migrationModel, ReasonChecksumUnchanged, "checksum "+migrationModel.Checksum)
 			continue
 		}
 
+		if migration.repeatUnconditional {
+			p.decisions.plan(migrationModel, ReasonRepeatUnconditional, "")
+		} else {
+			p.decisions.plan(migrationModel, ReasonChecksumChanged, fmt.Sprintf(
+				"saved checksum %q, registered checksum %q", migrationModel.Checksum, migration.checksum,
+			))
+		}
 		plan.migrationsToRun.PushBack(migrationModel)
 	}
 }
@@ -151,7 +308,7 @@ func (p *migratePlanner) findRelevantBaseline() (models.MigrationModel, bool) {
 		}
 
 		version := mustParseVersion(migrationModel.Version)
-		if version.LessOrEqual(p.manager.targetVersion) {
+		if version.LessOrEqual(p.targetVersion) {
 			latestBaselineMigration = migrationModel
 			latestBaselineMigrationFound = true
 		}
@@ -160,12 +317,28 @@ func (p *migratePlanner) findRelevantBaseline() (models.MigrationModel, bool) {
 	return latestBaselineMigration, latestBaselineMigrationFound
 }
 
+// plannerLogger возвращает логгер планировщика: логгер управляющего или silentLogger, если silent = true.
+func plannerLogger(manager *MigrationManager, silent bool) *log.Logger {
+	if silent {
+		return silentLogger
+	}
+	return manager.logger
+}
+
 type downgradePlanner struct {
 	manager         *MigrationManager
+	targetVersion   Version
+	savedVersion    Version
 	savedMigrations []models.MigrationModel
+	// silent - решения не выводятся в лог, например, при построении Status
+	silent bool
+
+	decisions decisionRecorder
 }
 
 func (p *downgradePlanner) MakePlan() migrationsPlan {
+	p.decisions = decisionRecorder{logger: plannerLogger(p.manager, p.silent), log: p.manager.explainPlan}
+
 	plan := newMigrationsPlan()
 
 	sort.SliceStable(p.savedMigrations, func(i, j int) bool {
@@ -179,20 +352,30 @@ func (p *downgradePlanner) MakePlan() migrationsPlan {
 		migrationVersion := mustParseVersion(migrationModel.Version)
 
 		if migrationModel.Type != string(TypeVersioned) {
+			p.decisions.skip(migrationModel, ReasonNotVersioned, "only versioned migrations are undone")
+			continue
+		}
+		if migrationVersion.MoreThan(p.savedVersion) {
+			p.decisions.skip(migrationModel, ReasonAboveSavedVersion, "saved version "+p.savedVersion.String())
 			continue
 		}
-		if migrationVersion.MoreThan(p.manager.getSavedAppVersion()) {
+		if migrationVersion.LessOrEqual(p.targetVersion) {
+			p.decisions.skip(migrationModel, ReasonNotAboveTarget, "target version "+p.targetVersion.String())
 			continue
 		}
-		if migrationVersion.LessOrEqual(p.manager.targetVersion) {
+		if migrationModel.State == models.StateUndone || migrationModel.State == models.StateReverted {
+			p.decisions.skip(migrationModel, ReasonAlreadyUndone, "")
 			continue
 		}
-		if migrationModel.State == models.StateUndone {
+		if migrationModel.State == models.StateFailedIgnored {
+			p.decisions.skip(migrationModel, ReasonNotApplied, "failed, see WithAllowFailure")
 			continue
 		}
 
+		p.decisions.plan(migrationModel, ReasonUndo, "target version "+p.targetVersion.String())
 		plan.migrationsToRun.PushBack(migrationModel)
 	}
 
+	plan.decisions = p.decisions.decisions
 	return plan
 }
@@ -0,0 +1,315 @@
+package go_migrator
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"io"
+	"log"
+	"testing"
+)
+
+func newTestManager() *MigrationManager {
+	return &MigrationManager{
+		logger:                  log.New(io.Discard, "", 0),
+		registeredMigrationsSet: make(map[uint32]*Migration),
+	}
+}
+
+func newTestModel(migrationType MigrationType, version string, state models.MigrationState) models.MigrationModel {
+	return models.MigrationModel{
+		Id:      getMigrationIdentifier(version, string(migrationType)),
+		Type:    string(migrationType),
+		Version: version,
+		State:   state,
+	}
+}
+
+func planVersions(plan migrationsPlan) []string {
+	versions := make([]string, 0)
+	for _, migrationModel := range plan.Migrations() {
+		versions = append(versions, migrationModel.Version)
+	}
+	return versions
+}
+
+func assertPlanVersions(t *testing.T, plan migrationsPlan, expected []string) {
+	t.Helper()
+
+	versions := planVersions(plan)
+	if len(versions) != len(expected) {
+		t.Fatalf("expected plan %v, got %v", expected, versions)
+	}
+	for i, _ := range versions {
+		if versions[i] != expected[i] {
+			t.Fatalf("expected plan %v, got %v", expected, versions)
+		}
+	}
+}
+
+func findDecision(t *testing.T, decisions []PlanDecision, version string) PlanDecision {
+	t.Helper()
+
+	for _, decision := range decisions {
+		if decision.Version == version {
+			return decision
+		}
+	}
+	t.Fatalf("no decision for version %s", version)
+	return PlanDecision{}
+}
+
+func TestMigratePlannerReverted(t *testing.T) {
+	tests := []struct {
+		name          string
+		reapplyUndone bool
+		savedVersion  string
+		plan          []string
+		reasons       map[string]DecisionReason
+	}{
+		{
+			name:         "reverted migrations are not reapplied by default",
+			savedVersion: "1.2.0",
+			plan:         []string{"1.4.0.0"},
+			reasons: map[string]DecisionReason{
+				"1.1.0.0": ReasonReverted,
+				"1.3.0.0": ReasonReverted,
+				"1.4.0.0": ReasonPending,
+			},
+		},
+		{
+			name:          "reverted migrations are reapplied with option",
+			reapplyUndone: true,
+			savedVersion:  "1.2.0",
+			plan:          []string{"1.1.0.0", "1.3.0.0", "1.4.0.0"},
+			reasons: map[string]DecisionReason{
+				"1.1.0.0": ReasonReapplyUndone,
+				"1.3.0.0": ReasonReapplyUndone,
+				"1.4.0.0": ReasonPending,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newTestManager()
+			manager.reapplyUndone = tt.reapplyUndone
+
+			planner := migratePlanner{
+				manager:       manager,
+				targetVersion: mustParseVersion("1.4.0"),
+				savedVersion:  mustParseVersion(tt.savedVersion),
+				savedMigrations: []models.MigrationModel{
+					newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+					newTestModel(TypeVersioned, "1.1.0.0", models.StateReverted),
+					newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+					// откачена UndoMigration, сохраненная версия понижена до 1.2.0
+					newTestModel(TypeVersioned, "1.3.0.0", models.StateReverted),
+					// откачена Downgrade
+					newTestModel(TypeVersioned, "1.4.0.0", models.StateUndone),
+				},
+			}
+
+			plan := planner.MakePlan()
+			assertPlanVersions(t, plan, tt.plan)
+			for version, reason := range tt.reasons {
+				decision := findDecision(t, plan.Decisions(), version)
+				if decision.Reason != reason {
+					t.Fatalf("version %s: expected reason %s, got %s", version, reason, decision.Reason)
+				}
+			}
+		})
+	}
+}
+
+func TestDowngradePlannerReasons(t *testing.T) {
+	planner := downgradePlanner{
+		manager:       newTestManager(),
+		targetVersion: mustParseVersion("1.1.0"),
+		savedVersion:  mustParseVersion("1.4.0"),
+		savedMigrations: []models.MigrationModel{
+			newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+			newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+			newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+			newTestModel(TypeVersioned, "1.3.0.0", models.StateReverted),
+			newTestModel(TypeVersioned, "1.4.0.0", models.StateSuccess),
+			newTestModel(TypeVersioned, "1.5.0.0", models.StateRegistered),
+		},
+	}
+
+	plan := planner.MakePlan()
+	assertPlanVersions(t, plan, []string{"1.4.0.0", "1.2.0.0"})
+
+	reasons := map[string]DecisionReason{
+		"1.0.0.0": ReasonNotVersioned,
+		"1.1.0.0": ReasonNotAboveTarget,
+		"1.2.0.0": ReasonUndo,
+		"1.3.0.0": ReasonAlreadyUndone,
+		"1.4.0.0": ReasonUndo,
+		"1.5.0.0": ReasonAboveSavedVersion,
+	}
+	for version, reason := range reasons {
+		decision := findDecision(t, plan.Decisions(), version)
+		if decision.Reason != reason {
+			t.Fatalf("version %s: expected reason %s, got %s", version, reason, decision.Reason)
+		}
+	}
+}
+
+func TestDowngradePlannerFailedIgnored(t *testing.T) {
+	savedMigrations := []models.MigrationModel{
+		newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+		// завершилась разрешенной ошибкой, версию повысила следующая миграция
+		newTestModel(TypeVersioned, "1.2.0.0", models.StateFailedIgnored),
+		newTestModel(TypeVersioned, "1.3.0.0", models.StateSuccess),
+	}
+
+	planner := downgradePlanner{
+		manager:         newTestManager(),
+		targetVersion:   mustParseVersion("1.0.0"),
+		savedVersion:    mustParseVersion("1.3.0"),
+		savedMigrations: append([]models.MigrationModel(nil), savedMigrations...),
+	}
+
+	plan := planner.MakePlan()
+	assertPlanVersions(t, plan, []string{"1.3.0.0", "1.1.0.0"})
+
+	decision := findDecision(t, plan.Decisions(), "1.2.0.0")
+	if decision.Planned || decision.Reason != ReasonNotApplied {
+		t.Fatalf("expected failed-ignored migration to be skipped with %s, got %+v", ReasonNotApplied, decision)
+	}
+
+	version := previousVersion(savedMigrations[3], savedMigrations)
+	if version.String() != "1.1.0.0" {
+		t.Fatalf("expected version 1.1.0.0 after undoing 1.3.0.0, got %s", version)
+	}
+}
+
+func TestMigratePlannerFailedIgnored(t *testing.T) {
+	planner := migratePlanner{
+		manager:       newTestManager(),
+		targetVersion: mustParseVersion("1.3.0"),
+		savedVersion:  mustParseVersion("1.1.0"),
+		savedMigrations: []models.MigrationModel{
+			newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess),
+			newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+			// сохраненная версия не повышается миграцией, завершившейся разрешенной ошибкой
+			newTestModel(TypeVersioned, "1.2.0.0", models.StateFailedIgnored),
+			newTestModel(TypeVersioned, "1.3.0.0", models.StateRegistered),
+		},
+	}
+
+	plan := planner.MakePlan()
+	assertPlanVersions(t, plan, []string{"1.3.0.0"})
+
+	decision := findDecision(t, plan.Decisions(), "1.2.0.0")
+	if decision.Planned || decision.Reason != ReasonFailedIgnored {
+		t.Fatalf("expected failed-ignored migration to be skipped with %s, got %+v", ReasonFailedIgnored, decision)
+	}
+}
+
+func TestMigrationsPlanLimitSteps(t *testing.T) {
+	newPlan := func() migrationsPlan {
+		plan := newMigrationsPlan()
+		recorder := decisionRecorder{logger: silentLogger}
+		for _, migrationModel := range []models.MigrationModel{
+			newTestModel(TypeBaseline, "1.0.0.0", models.StateRegistered),
+			newTestModel(TypeVersioned, "1.1.0.0", models.StateRegistered),
+			newTestModel(TypeVersioned, "1.2.0.0", models.StateRegistered),
+			newTestModel(TypeRepeatable, "1.9.0.0", models.StateRegistered),
+		} {
+			recorder.plan(migrationModel, ReasonPending, "")
+			plan.migrationsToRun.PushBack(migrationModel)
+		}
+		plan.decisions = recorder.decisions
+		return plan
+	}
+
+	tests := []struct {
+		name  string
+		steps int
+		plan  []string
+		cut   []string
+	}{
+		{
+			name:  "single step",
+			steps: 1,
+			plan:  []string{"1.0.0.0"},
+			cut:   []string{"1.1.0.0", "1.2.0.0", "1.9.0.0"},
+		},
+		{
+			name:  "repeatable is cut with versioned",
+			steps: 2,
+			plan:  []string{"1.0.0.0", "1.1.0.0"},
+			cut:   []string{"1.2.0.0", "1.9.0.0"},
+		},
+		{
+			name:  "all steps",
+			steps: 3,
+			plan:  []string{"1.0.0.0", "1.1.0.0", "1.2.0.0", "1.9.0.0"},
+		},
+		{
+			name:  "more steps than planned",
+			steps: 10,
+			plan:  []string{"1.0.0.0", "1.1.0.0", "1.2.0.0", "1.9.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limited := newPlan().limitSteps(tt.steps)
+			assertPlanVersions(t, limited, tt.plan)
+
+			for _, version := range tt.cut {
+				decision := findDecision(t, limited.Decisions(), version)
+				if decision.Planned || decision.Reason != ReasonStepLimit {
+					t.Fatalf("version %s: expected %s decision, got %+v", version, ReasonStepLimit, decision)
+				}
+			}
+			for _, version := range tt.plan {
+				decision := findDecision(t, limited.Decisions(), version)
+				if !decision.Planned || decision.Reason != ReasonPending {
+					t.Fatalf("version %s: expected %s decision, got %+v", version, ReasonPending, decision)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrationsPlanFilter(t *testing.T) {
+	plan := newMigrationsPlan()
+	recorder := decisionRecorder{logger: silentLogger}
+	for _, migrationModel := range []models.MigrationModel{
+		newTestModel(TypeVersioned, "1.3.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.2.0.0", models.StateSuccess),
+		newTestModel(TypeVersioned, "1.1.0.0", models.StateSuccess),
+	} {
+		recorder.plan(migrationModel, ReasonUndo, "")
+		plan.migrationsToRun.PushBack(migrationModel)
+	}
+	recorder.skip(newTestModel(TypeBaseline, "1.0.0.0", models.StateSuccess), ReasonNotVersioned, "")
+	plan.decisions = recorder.decisions
+
+	filtered := plan.filter(func(migrationModel models.MigrationModel) bool {
+		return migrationModel.Version != "1.2.0.0"
+	}, ReasonNotInRun, "run r1")
+	assertPlanVersions(t, filtered, []string{"1.3.0.0", "1.1.0.0"})
+
+	expected := map[string]DecisionReason{
+		"1.3.0.0": ReasonUndo,
+		"1.2.0.0": ReasonNotInRun,
+		"1.1.0.0": ReasonUndo,
+		"1.0.0.0": ReasonNotVersioned,
+	}
+	for version, reason := range expected {
+		decision := findDecision(t, filtered.Decisions(), version)
+		if decision.Reason != reason || decision.Planned != (reason == ReasonUndo) {
+			t.Fatalf("version %s: expected reason %s, got %+v", version, reason, decision)
+		}
+	}
+	if decision := findDecision(t, filtered.Decisions(), "1.2.0.0"); decision.Details != "run r1" {
+		t.Fatalf("expected filter details, got %q", decision.Details)
+	}
+
+	// исходный план не изменяется
+	assertPlanVersions(t, plan, []string{"1.3.0.0", "1.2.0.0", "1.1.0.0"})
+}
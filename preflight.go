@@ -0,0 +1,283 @@
+package go_migrator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"strings"
+)
+
+var (
+	ErrPreflightFailed = errors.New("pre-flight checks failed")
+)
+
+// PreflightCheck - проверка окружения, выполняемая перед Migrate и Downgrade до создания системных таблиц.
+type PreflightCheck interface {
+	// Name возвращает название проверки для отчета.
+	Name() string
+	// Check возвращает описание проверенного состояния окружения и ошибку, если окружение не удовлетворяет проверке.
+	Check(ctx context.Context, db *gorm.DB) (string, error)
+}
+
+// PreflightResult - результат выполнения одной проверки.
+type PreflightResult struct {
+	Name    string
+	Passed  bool
+	Details string
+	Err     error
+}
+
+func (r PreflightResult) String() string {
+	if r.Passed {
+		return fmt.Sprintf("[ok] %s: %s", r.Name, r.Details)
+	}
+	if r.Details == "" {
+		return fmt.Sprintf("[failed] %s: %s", r.Name, r.Err)
+	}
+	return fmt.Sprintf("[failed] %s: %s (%s)", r.Name, r.Err, r.Details)
+}
+
+// PreflightReport содержит результаты всех проверок в порядке их регистрации.
+type PreflightReport struct {
+	Results []PreflightResult
+}
+
+// Passed определяет, пройдены ли все проверки.
+func (r *PreflightReport) Passed() bool {
+	for _, result := range r.Results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed возвращает результаты непройденных проверок.
+func (r *PreflightReport) Failed() []PreflightResult {
+	failed := make([]PreflightResult, 0)
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func (r *PreflightReport) String() string {
+	lines := make([]string, 0, len(r.Results))
+	for _, result := range r.Results {
+		lines = append(lines, result.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PreflightError возвращается, если не пройдена хотя бы одна проверка. Соответствует ErrPreflightFailed при проверке
+// через errors.Is.
+type PreflightError struct {
+	Report *PreflightReport
+}
+
+func (e *PreflightError) Error() string {
+	failed := e.Report.Failed()
+	messages := make([]string, 0, len(failed))
+	for _, result := range failed {
+		messages = append(messages, result.String())
+	}
+	return fmt.Sprintf("%s: %s", ErrPreflightFailed, strings.Join(messages, "; "))
+}
+
+func (e *PreflightError) Is(target error) bool {
+	return target == ErrPreflightFailed
+}
+
+// RunPreflightChecks выполняет проверки, заданные с помощью WithPreflightChecks, и возвращает отчет. Проверки
+// выполняются на соединении, инициализированном так же, как соединения миграций (см. WithSessionInit). Если хотя бы
+// одна проверка не пройдена, вместе с отчетом возвращается *PreflightError, а при ошибке инициализации соединения -
+// только ошибка.
+func (m *MigrationManager) RunPreflightChecks(ctx context.Context) (*PreflightReport, error) {
+	report := &PreflightReport{Results: make([]PreflightResult, 0, len(m.preflightChecks))}
+	if len(m.preflightChecks) == 0 {
+		return report, nil
+	}
+
+	err := m.withSessionConnection(m.primaryDB(ctx), func(session *gorm.DB) error {
+		err := m.initSession(session)
+		if err != nil {
+			return err
+		}
+
+		for _, check := range m.preflightChecks {
+			details, err := check.Check(ctx, session)
+			report.Results = append(report.Results, PreflightResult{
+				Name:    check.Name(),
+				Passed:  err == nil,
+				Details: details,
+				Err:     err,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !report.Passed() {
+		return report, &PreflightError{Report: report}
+	}
+	return report, nil
+}
+
+func (m *MigrationManager) checkPreflight(ctx context.Context) error {
+	if len(m.preflightChecks) == 0 {
+		return nil
+	}
+
+	report, err := m.RunPreflightChecks(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.logger.Printf("Pre-flight checks passed:\n%s\n", report)
+	return nil
+}
+
+// NotInRecoveryCheck запрещает выполнение на сервере в режиме восстановления (hot standby), где запись невозможна.
+func NotInRecoveryCheck() PreflightCheck {
+	return notInRecoveryCheck{}
+}
+
+type notInRecoveryCheck struct{}
+
+func (c notInRecoveryCheck) Name() string {
+	return "not in recovery"
+}
+
+func (c notInRecoveryCheck) Check(_ context.Context, db *gorm.DB) (string, error) {
+	inRecovery, err := repository.IsInRecovery(db)
+	if err != nil {
+		return "", err
+	}
+	if inRecovery {
+		return "pg_is_in_recovery() = true", errors.New("server is a hot standby")
+	}
+	return "pg_is_in_recovery() = false", nil
+}
+
+// MinServerVersionCheck требует версию сервера не ниже minVersionNum в формате server_version_num, например,
+// 120000 для PostgreSQL 12.
+func MinServerVersionCheck(minVersionNum int) PreflightCheck {
+	return minServerVersionCheck{minVersionNum: minVersionNum}
+}
+
+type minServerVersionCheck struct {
+	minVersionNum int
+}
+
+func (c minServerVersionCheck) Name() string {
+	return "minimum server version"
+}
+
+func (c minServerVersionCheck) Check(_ context.Context, db *gorm.DB) (string, error) {
+	versionNum, err := repository.GetServerVersionNum(db)
+	if err != nil {
+		return "", err
+	}
+	version, err := repository.GetServerVersion(db)
+	if err != nil {
+		return "", err
+	}
+
+	details := fmt.Sprintf("server version %s (%d), required %d", version, versionNum, c.minVersionNum)
+	if versionNum < c.minVersionNum {
+		return details, errors.New("server version is too old")
+	}
+	return details, nil
+}
+
+// RequiredExtensionsCheck требует, чтобы расширения были установлены в базе данных.
+func RequiredExtensionsCheck(extensions ...string) PreflightCheck {
+	return requiredExtensionsCheck{extensions: extensions}
+}
+
+type requiredExtensionsCheck struct {
+	extensions []string
+}
+
+func (c requiredExtensionsCheck) Name() string {
+	return "required extensions"
+}
+
+func (c requiredExtensionsCheck) Check(_ context.Context, db *gorm.DB) (string, error) {
+	if len(c.extensions) == 0 {
+		return "no extensions required", nil
+	}
+
+	installed, err := repository.GetInstalledExtensions(db, c.extensions)
+	if err != nil {
+		return "", err
+	}
+
+	installedSet := make(map[string]struct{}, len(installed))
+	for _, extension := range installed {
+		installedSet[extension] = struct{}{}
+	}
+
+	missing := make([]string, 0)
+	for _, extension := range c.extensions {
+		if _, ok := installedSet[extension]; !ok {
+			missing = append(missing, extension)
+		}
+	}
+
+	details := fmt.Sprintf("installed: [%s]", strings.Join(installed, ", "))
+	if len(missing) != 0 {
+		return details, fmt.Errorf("extensions are not installed: %s", strings.Join(missing, ", "))
+	}
+	return details, nil
+}
+
+// SchemaCreatePrivilegeCheck требует привилегию CREATE на схему у текущей роли. При пустом schema проверяется
+// текущая схема (current_schema()).
+func SchemaCreatePrivilegeCheck(schema string) PreflightCheck {
+	return schemaCreatePrivilegeCheck{schema: schema}
+}
+
+type schemaCreatePrivilegeCheck struct {
+	schema string
+}
+
+func (c schemaCreatePrivilegeCheck) Name() string {
+	return "schema create privilege"
+}
+
+func (c schemaCreatePrivilegeCheck) Check(_ context.Context, db *gorm.DB) (string, error) {
+	schema := c.schema
+	if schema == "" {
+		var err error
+		schema, err = repository.GetCurrentSchema(db)
+		if err != nil {
+			return "", err
+		}
+		if schema == "" {
+			return "", errors.New("current schema is not set, check search_path")
+		}
+	}
+
+	user, err := repository.GetCurrentUser(db)
+	if err != nil {
+		return "", err
+	}
+
+	granted, err := repository.HasSchemaCreatePrivilege(db, schema)
+	if err != nil {
+		return "", err
+	}
+
+	details := fmt.Sprintf("role %s, schema %s", user, schema)
+	if !granted {
+		return details, errors.New("role has no CREATE privilege on schema")
+	}
+	return details, nil
+}
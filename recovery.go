@@ -0,0 +1,225 @@
+package go_migrator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+var (
+	ErrHasInterruptedMigrations = errors.New("found migrations interrupted while running, consider recovering your db")
+	ErrMigrationsInProgress     = errors.New("migrations are running by another process")
+)
+
+// RecoveryPolicy определяет, как поступать с миграциями, оставшимися в состоянии models.StateRunning после
+// аварийного завершения процесса.
+type RecoveryPolicy string
+
+const (
+	// RecoveryFail прерывает запуск с ошибкой *InterruptedMigrationsError, не изменяя состояния миграций.
+	RecoveryFail RecoveryPolicy = "fail"
+	// RecoveryRetry возвращает миграциям состояние, предшествовавшее запуску, после чего они планируются повторно.
+	// Безопасна для транзакционных миграций, нетранзакционная миграция могла быть применена частично.
+	RecoveryRetry RecoveryPolicy = "retry"
+	// RecoveryMarkFailed помечает миграции как models.StateFailure и прерывает запуск, требуя ручного исправления.
+	RecoveryMarkFailed RecoveryPolicy = "mark-failed"
+)
+
+// InterruptedMigration описывает миграцию, выполнение которой было прервано.
+type InterruptedMigration struct {
+	Type          MigrationType
+	Version       string
+	Owner         string
+	StartedOn     *time.Time
+	PreviousState models.MigrationState
+	Transaction   bool
+}
+
+// InterruptedMigrationsError возвращается при обнаружении прерванных миграций. Соответствует
+// ErrHasInterruptedMigrations при проверке через errors.Is.
+type InterruptedMigrationsError struct {
+	Policy     RecoveryPolicy
+	Migrations []InterruptedMigration
+}
+
+func (e *InterruptedMigrationsError) Error() string {
+	descriptions := make([]string, 0, len(e.Migrations))
+	for _, migration := range e.Migrations {
+		startedOn := "unknown"
+		if migration.StartedOn != nil {
+			startedOn = migration.StartedOn.Format(time.RFC3339)
+		}
+
+		descriptions = append(descriptions, fmt.Sprintf(
+			"%s %s (owner: %s, started: %s)",
+			migration.Type, migration.Version, migration.Owner, startedOn,
+		))
+	}
+
+	return fmt.Sprintf(
+		"%s, recovery policy %s: %s",
+		ErrHasInterruptedMigrations, e.Policy, strings.Join(descriptions, "; "),
+	)
+}
+
+func (e *InterruptedMigrationsError) Is(target error) bool {
+	return target == ErrHasInterruptedMigrations
+}
+
+// HasInterruptedMigrations определяет есть ли миграции, выполнение которых было прервано аварийным завершением
+// процесса.
+func (m *MigrationManager) HasInterruptedMigrations() (bool, error) {
+	if !repository.HasMigrationsTable(m.db) {
+		return false, nil
+	}
+
+	interrupted, err := m.findInterruptedMigrations(m.db)
+	if err != nil {
+		return false, err
+	}
+	return len(interrupted) != 0, nil
+}
+
+// recoverInterruptedMigrations применяет политику восстановления к миграциям в состоянии models.StateRunning.
+// Вызывается в начале Migrate и Downgrade под блокировкой, когда ни одна миграция текущего запуска еще не
+// выполняется. Миграция считается прерванной, только если задан Locker, т.к. удерживаемая блокировка исключает
+// выполнение миграций другим процессом, или если она запущена раньше, чем WithRecoveryStaleAfter назад. Иначе
+// миграция может выполняться другим экземпляром приложения, и запуск прерывается с ErrMigrationsInProgress без
+// изменения ее состояния.
+func (m *MigrationManager) recoverInterruptedMigrations(db *gorm.DB) error {
+	runningModels, err := m.findInterruptedMigrations(db)
+	if err != nil {
+		return err
+	}
+	if len(runningModels) == 0 {
+		return nil
+	}
+
+	interruptedModels, inProgressModels := m.splitRunningMigrations(runningModels, m.locker != nil, time.Now())
+	if len(inProgressModels) != 0 {
+		return newMigrationsInProgressError(inProgressModels)
+	}
+
+	interruptedErr := m.newInterruptedMigrationsError(interruptedModels)
+	m.logger.Println("Interrupted migrations found:", interruptedErr)
+
+	switch m.recoveryPolicy {
+	case RecoveryRetry:
+		for i, _ := range interruptedModels {
+			previousState := retryState(interruptedModels[i])
+
+			m.logger.Printf(
+				"migration (type: %s, version: %s) state restored to %s for retry\n",
+				interruptedModels[i].Type, interruptedModels[i].Version, previousState,
+			)
+			err = repository.UpdateMigrationState(db, &interruptedModels[i], previousState)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case RecoveryMarkFailed:
+		for i, _ := range interruptedModels {
+			err = repository.UpdateMigrationStateFailed(db, &interruptedModels[i], models.StateFailure, interruptedErr)
+			if err != nil {
+				return err
+			}
+		}
+		return interruptedErr
+
+	default:
+		return interruptedErr
+	}
+}
+
+func (m *MigrationManager) newInterruptedMigrationsError(
+	interruptedModels []models.MigrationModel,
+) *InterruptedMigrationsError {
+	interruptedErr := &InterruptedMigrationsError{Policy: m.recoveryPolicy}
+	for _, migrationModel := range interruptedModels {
+		migration, ok := m.findMigration(migrationModel)
+
+		interruptedErr.Migrations = append(interruptedErr.Migrations, InterruptedMigration{
+			Type:          MigrationType(migrationModel.Type),
+			Version:       migrationModel.Version,
+			Owner:         migrationModel.Owner,
+			StartedOn:     migrationModel.StartedOn,
+			PreviousState: migrationModel.PreviousState,
+			Transaction:   ok && migration.transaction,
+		})
+	}
+	return interruptedErr
+}
+
+// splitRunningMigrations разделяет миграции в состоянии models.StateRunning на прерванные и, возможно, выполняемые
+// другим процессом. locked означает, что текущий процесс удерживает Locker.
+func (m *MigrationManager) splitRunningMigrations(
+	runningModels []models.MigrationModel,
+	locked bool,
+	now time.Time,
+) (interrupted, inProgress []models.MigrationModel) {
+	interrupted = make([]models.MigrationModel, 0, len(runningModels))
+	inProgress = make([]models.MigrationModel, 0)
+	for _, migrationModel := range runningModels {
+		if locked || m.isStale(migrationModel, now) {
+			interrupted = append(interrupted, migrationModel)
+			continue
+		}
+		inProgress = append(inProgress, migrationModel)
+	}
+	return interrupted, inProgress
+}
+
+// isStale определяет, запущена ли миграция раньше, чем WithRecoveryStaleAfter назад. Миграция без времени запуска
+// сохранена версией библиотеки, не отмечавшей его, и считается устаревшей.
+func (m *MigrationManager) isStale(migrationModel models.MigrationModel, now time.Time) bool {
+	if m.recoveryStaleAfter <= 0 {
+		return false
+	}
+	if migrationModel.StartedOn == nil {
+		return true
+	}
+	return now.Sub(*migrationModel.StartedOn) >= m.recoveryStaleAfter
+}
+
+func newMigrationsInProgressError(inProgressModels []models.MigrationModel) error {
+	descriptions := make([]string, 0, len(inProgressModels))
+	for _, migrationModel := range inProgressModels {
+		descriptions = append(descriptions, fmt.Sprintf(
+			"%s %s (owner: %s)", migrationModel.Type, migrationModel.Version, migrationModel.Owner,
+		))
+	}
+
+	return fmt.Errorf(
+		"%w, use Locker or WithRecoveryStaleAfter to recover interrupted migrations: %s",
+		ErrMigrationsInProgress, strings.Join(descriptions, "; "),
+	)
+}
+
+// retryState возвращает состояние, которое RecoveryRetry восстанавливает прерванной миграции.
+func retryState(migrationModel models.MigrationModel) models.MigrationState {
+	if migrationModel.PreviousState == "" {
+		return models.StateRegistered
+	}
+	return migrationModel.PreviousState
+}
+
+func (m *MigrationManager) findInterruptedMigrations(db *gorm.DB) ([]models.MigrationModel, error) {
+	savedMigrations, err := m.loadSavedMigrations(db, repository.OrderASC)
+	if err != nil {
+		return nil, err
+	}
+
+	interrupted := make([]models.MigrationModel, 0)
+	for i, _ := range savedMigrations {
+		if savedMigrations[i].State == models.StateRunning {
+			interrupted = append(interrupted, savedMigrations[i])
+		}
+	}
+	return interrupted, nil
+}
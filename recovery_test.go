@@ -0,0 +1,73 @@
+package go_migrator
+
+import (
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"testing"
+	"time"
+)
+
+func TestSplitRunningMigrations(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	startedAt := func(ago time.Duration) *time.Time {
+		startedOn := now.Add(-ago)
+		return &startedOn
+	}
+
+	runningModels := []models.MigrationModel{
+		{Version: "1.1.0.0", State: models.StateRunning, StartedOn: startedAt(time.Minute)},
+		{Version: "1.2.0.0", State: models.StateRunning, StartedOn: startedAt(2 * time.Hour)},
+		{Version: "1.3.0.0", State: models.StateRunning},
+	}
+
+	tests := []struct {
+		name        string
+		staleAfter  time.Duration
+		locked      bool
+		interrupted []string
+		inProgress  []string
+	}{
+		{
+			name:       "no locker and no threshold",
+			inProgress: []string{"1.1.0.0", "1.2.0.0", "1.3.0.0"},
+		},
+		{
+			name:        "locker held",
+			locked:      true,
+			interrupted: []string{"1.1.0.0", "1.2.0.0", "1.3.0.0"},
+		},
+		{
+			name:        "stale threshold",
+			staleAfter:  time.Hour,
+			interrupted: []string{"1.2.0.0", "1.3.0.0"},
+			inProgress:  []string{"1.1.0.0"},
+		},
+		{
+			name:        "threshold equals age",
+			staleAfter:  time.Minute,
+			interrupted: []string{"1.1.0.0", "1.2.0.0", "1.3.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MigrationManager{recoveryStaleAfter: tt.staleAfter}
+
+			interrupted, inProgress := m.splitRunningMigrations(runningModels, tt.locked, now)
+			assertVersions(t, "interrupted", interrupted, tt.interrupted)
+			assertVersions(t, "in progress", inProgress, tt.inProgress)
+		})
+	}
+}
+
+func assertVersions(t *testing.T, name string, migrationModels []models.MigrationModel, expected []string) {
+	t.Helper()
+
+	if len(migrationModels) != len(expected) {
+		t.Fatalf("%s: expected versions %v, got %d migrations", name, expected, len(migrationModels))
+	}
+	for i, _ := range migrationModels {
+		if migrationModels[i].Version != expected[i] {
+			t.Fatalf("%s: expected version %s at %d, got %s", name, expected[i], i, migrationModels[i].Version)
+		}
+	}
+}
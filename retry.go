@@ -0,0 +1,138 @@
+package go_migrator
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// jitter использует собственный источник, т.к. глобальный источник math/rand для модулей go 1.19 не
+	// инициализируется случайно, и экземпляры приложения получали бы одинаковые задержки
+	jitterMu     sync.Mutex
+	jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// RetryPolicy описывает повторное выполнение транзакционной миграции, завершившейся временной ошибкой базы данных.
+// Повтор безопасен только для миграций, выполняемых в собственной транзакции, поэтому к нетранзакционным миграциям
+// и миграциям внутри групповой транзакции политика не применяется.
+type RetryPolicy struct {
+	// MaxAttempts - максимальное количество попыток, включая первую.
+	MaxAttempts int
+	// InitialBackoff - задержка перед второй попыткой, каждая следующая задержка удваивается.
+	InitialBackoff time.Duration
+	// MaxBackoff - максимальная задержка между попытками, нулевое значение не ограничивает задержку.
+	MaxBackoff time.Duration
+	// Retryable определяет, допускает ли ошибка повтор. По умолчанию используется IsTransientError.
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy возвращает политику с тремя попытками и задержкой от 100мс до 5с.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Retryable:      IsTransientError,
+	}
+}
+
+// transientSQLStates - коды ошибок Postgres, после которых транзакцию можно безопасно повторить:
+// serialization_failure, deadlock_detected и lock_not_available.
+var transientSQLStates = map[string]struct{}{
+	"40001": {},
+	"40P01": {},
+	"55P03": {},
+}
+
+// IsTransientError определяет, является ли ошибка временной ошибкой сериализации, взаимной блокировки или ожидания
+// блокировки. Код ошибки определяется через метод SQLState, реализованный ошибками драйверов pgx и lib/pq.
+func IsTransientError(err error) bool {
+	var sqlStateErr interface {
+		SQLState() string
+	}
+	if !errors.As(err, &sqlStateErr) {
+		return false
+	}
+
+	_, ok := transientSQLStates[sqlStateErr.SQLState()]
+	return ok
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return IsTransientError(err)
+	}
+	return p.Retryable(err)
+}
+
+// backoff возвращает задержку перед попыткой attempt + 1 со случайным разбросом в половину задержки.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < attempt; i++ {
+		// нулевой MaxBackoff не ограничивает задержку, но удвоение не должно переполнить time.Duration
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff || delay > math.MaxInt64/2 {
+			break
+		}
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return half + time.Duration(jitterSource.Int63n(int64(half)+1))
+}
+
+// retryPolicyFor возвращает политику повторов для миграции: собственную политику миграции или политику управляющего.
+func (m *MigrationManager) retryPolicyFor(migration *Migration) (RetryPolicy, bool) {
+	if !migration.transaction || m.groupTransaction {
+		return RetryPolicy{}, false
+	}
+
+	if migration.retryPolicy != nil {
+		return *migration.retryPolicy, true
+	}
+	if m.retryPolicy != nil {
+		return *m.retryPolicy, true
+	}
+	return RetryPolicy{}, false
+}
+
+// withRetries выполняет fn с учетом политики повторов миграции и возвращает количество выполненных попыток.
+func (m *MigrationManager) withRetries(ctx context.Context, migration *Migration, fn func() error) (int, error) {
+	policy, ok := m.retryPolicyFor(migration)
+	if !ok || policy.MaxAttempts <= 1 {
+		return 1, fn()
+	}
+
+	attempt := 1
+	for {
+		err := fn()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return attempt, err
+		}
+
+		delay := policy.backoff(attempt)
+		m.logger.Printf(
+			"Attempt %d/%d failed with retryable error: %s. Retrying in %s\n",
+			attempt, policy.MaxAttempts, err, delay,
+		)
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(delay):
+		}
+		attempt++
+	}
+}
@@ -0,0 +1,64 @@
+package go_migrator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		// задержка без случайного разброса, фактическая задержка лежит в [expected/2, expected]
+		expected time.Duration
+	}{
+		{
+			name:     "first retry",
+			policy:   RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+			attempt:  1,
+			expected: 100 * time.Millisecond,
+		},
+		{
+			name:     "doubled",
+			policy:   RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+			attempt:  3,
+			expected: 400 * time.Millisecond,
+		},
+		{
+			name:     "capped by max backoff",
+			policy:   RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second},
+			attempt:  10,
+			expected: time.Second,
+		},
+		{
+			name:     "zero max backoff does not cap",
+			policy:   RetryPolicy{InitialBackoff: 100 * time.Millisecond},
+			attempt:  5,
+			expected: 1600 * time.Millisecond,
+		},
+		{
+			name:     "zero max backoff does not overflow",
+			policy:   RetryPolicy{InitialBackoff: time.Second},
+			attempt:  200,
+			expected: time.Second << 33,
+		},
+		{
+			name:     "zero initial backoff",
+			policy:   RetryPolicy{MaxBackoff: time.Second},
+			attempt:  3,
+			expected: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 20; i++ {
+				delay := tt.policy.backoff(tt.attempt)
+				if delay < tt.expected/2 || delay > tt.expected {
+					t.Fatalf("expected delay in [%s, %s], got %s", tt.expected/2, tt.expected, delay)
+				}
+			}
+		})
+	}
+}
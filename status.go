@@ -0,0 +1,291 @@
+package go_migrator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// MigrationStatus описывает миграцию, объединяя зарегистрированную миграцию и строку таблицы migrations. Для
+// несохраненной миграции Rank равен нулю, а State, RegisteredOn и ExecutedOn не заданы.
+type MigrationStatus struct {
+	Rank            int
+	Type            MigrationType
+	Version         string
+	Description     string
+	State           models.MigrationState
+	RegisteredOn    *time.Time
+	ExecutedOn      *time.Time
+	SavedChecksum   string
+	CurrentChecksum string
+	// RunID - идентификатор запуска, в котором миграция была успешно выполнена (см. RollbackRun).
+	RunID string
+
+	// Pending - миграция будет выполнена следующим запуском Migrate.
+	Pending bool
+	// MissingInCode - миграция сохранена, но не зарегистрирована.
+	MissingInCode bool
+	// ChecksumChanged - контрольная сумма зарегистрированной миграции отличается от сохраненной.
+	ChecksumChanged bool
+
+	// NextStep - номер шага миграции в плане Migrate: MigrateSteps(n) выполнит миграции с номерами от 1 до n. Ноль,
+	// если миграция не будет выполнена или имеет тип TypeRepeatable.
+	NextStep int
+	// UndoStep - номер шага миграции в плане отката: DowngradeSteps(n) откатит миграции с номерами от 1 до n. Ноль,
+	// если миграция не может быть откачена.
+	UndoStep int
+}
+
+// Flags возвращает установленные признаки миграции.
+func (s MigrationStatus) Flags() []string {
+	flags := make([]string, 0)
+	if s.Pending {
+		flags = append(flags, "pending")
+	}
+	if s.MissingInCode {
+		flags = append(flags, "missing-in-code")
+	}
+	if s.ChecksumChanged {
+		flags = append(flags, "checksum-changed")
+	}
+	return flags
+}
+
+// Status описывает состояние миграций базы данных.
+type Status struct {
+	SavedVersion  string
+	TargetVersion string
+	Migrations    []MigrationStatus
+	// Blocked - ошибка, с которой следующий запуск завершится до выполнения миграций, например, из-за прерванных
+	// миграций. Если она задана, ни одна миграция не отмечается Pending и шаги не нумеруются.
+	Blocked error
+}
+
+// WriteTable выводит состояние в w в виде текстовой таблицы.
+func (s *Status) WriteTable(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "saved version: %s\ntarget version: %s\n", s.SavedVersion, s.TargetVersion)
+	if err != nil {
+		return err
+	}
+	if s.Blocked != nil {
+		_, err = fmt.Fprintf(w, "blocked: %s\n", s.Blocked)
+		if err != nil {
+			return err
+		}
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, err = fmt.Fprintln(
+		table, "RANK\tTYPE\tVERSION\tDESCRIPTION\tSTATE\tREGISTERED\tEXECUTED\tRUN\tCHECKSUM\tNEXT\tUNDO\tFLAGS",
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range s.Migrations {
+		rank := "-"
+		if migration.Rank != 0 {
+			rank = strconv.Itoa(migration.Rank)
+		}
+
+		runID := orDash(migration.RunID)
+
+		checksum := migration.SavedChecksum
+		if migration.ChecksumChanged {
+			checksum = migration.SavedChecksum + " -> " + migration.CurrentChecksum
+		} else if checksum == "" {
+			checksum = migration.CurrentChecksum
+		}
+
+		_, err = fmt.Fprintf(
+			table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			rank, migration.Type, migration.Version, migration.Description, orDash(string(migration.State)),
+			formatStatusTime(migration.RegisteredOn), formatStatusTime(migration.ExecutedOn), runID, orDash(checksum),
+			formatStep(migration.NextStep), formatStep(migration.UndoStep), orDash(strings.Join(migration.Flags(), ",")),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return table.Flush()
+}
+
+func (s *Status) String() string {
+	var builder strings.Builder
+	// strings.Builder не возвращает ошибок записи
+	_ = s.WriteTable(&builder)
+	return builder.String()
+}
+
+// Status возвращает сохраненную и target версии и состояние каждой сохраненной или зарегистрированной миграции.
+// Шаги Migrate и отката вычисляются так, как их спланировал бы следующий запуск, в том числе с восстановлением
+// прерванных миграций (см. WithRecoveryPolicy). Если следующий запуск завершится ошибкой до выполнения миграций,
+// ошибка сохраняется в Status.Blocked, а шаги не вычисляются. База данных не изменяется.
+func (m *MigrationManager) Status() (*Status, error) {
+	targetVersion, err := m.resolveTargetVersion(m.targetVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	savedMigrations := make([]models.MigrationModel, 0)
+	if repository.HasMigrationsTable(m.db) {
+		savedMigrations, err = m.loadSavedMigrations(m.db, repository.OrderASC)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	savedVersion := Version{}
+	status := &Status{TargetVersion: targetVersion.String()}
+	if repository.HasVersionTable(m.db) {
+		savedVersion, err = m.getSavedAppVersion(m.db)
+		if err != nil {
+			return nil, err
+		}
+		status.SavedVersion = savedVersion.String()
+	}
+
+	for _, migrationModel := range savedMigrations {
+		registeredOn := migrationModel.RegisteredOn
+		migrationStatus := MigrationStatus{
+			Rank:          migrationModel.Rank,
+			Type:          MigrationType(migrationModel.Type),
+			Version:       migrationModel.Version,
+			Description:   migrationModel.Description,
+			State:         migrationModel.State,
+			RegisteredOn:  &registeredOn,
+			ExecutedOn:    migrationModel.ExecutedOn,
+			SavedChecksum: migrationModel.Checksum,
+			RunID:         migrationModel.RunId,
+		}
+
+		migration, ok := m.findMigration(migrationModel)
+		if ok {
+			migrationStatus.CurrentChecksum = migration.checksum
+			migrationStatus.ChecksumChanged = migration.migrationType == TypeRepeatable &&
+				migrationModel.Checksum != "" && migrationModel.Checksum != migration.checksum
+		} else {
+			migrationStatus.MissingInCode = true
+		}
+
+		status.Migrations = append(status.Migrations, migrationStatus)
+	}
+
+	for _, migration := range m.newMigrations(savedMigrations) {
+		status.Migrations = append(status.Migrations, MigrationStatus{
+			Type:            migration.migrationType,
+			Version:         migration.version,
+			Description:     migration.migrator.Description(),
+			CurrentChecksum: migration.checksum,
+		})
+	}
+
+	// несохраненные миграции и прерванные миграции учитываются при планировании так же, как в Migrate
+	migrationsToPlan, err := m.previewSavedMigrations(m.db)
+	if errors.Is(err, ErrMigrationsInProgress) || errors.Is(err, ErrHasInterruptedMigrations) ||
+		errors.Is(err, ErrOutOfOrderRegistration) {
+		status.Blocked = err
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	planner := migratePlanner{
+		manager:         m,
+		targetVersion:   targetVersion,
+		savedVersion:    savedVersion,
+		savedMigrations: migrationsToPlan,
+		silent:          true,
+	}
+	plan := planner.MakePlan()
+	for _, decision := range plan.Decisions() {
+		if !decision.Planned {
+			continue
+		}
+		if migrationStatus := status.find(decision.Type, decision.Version); migrationStatus != nil {
+			migrationStatus.Pending = !migrationStatus.MissingInCode
+		}
+	}
+	status.numberSteps(plan, func(migrationStatus *MigrationStatus, step int) {
+		migrationStatus.NextStep = step
+	})
+
+	// откатываются только сохраненные миграции: несохраненные строки предпросмотра исключаются по идентификатору,
+	// т.к. планировщик сортирует migrationsToPlan и порядок строк предпросмотра не сохраняется
+	savedIdentifiers := make(map[uint32]struct{}, len(savedMigrations))
+	for i, _ := range savedMigrations {
+		savedIdentifiers[getMigrationIdentifier(savedMigrations[i].Version, savedMigrations[i].Type)] = struct{}{}
+	}
+	migrationsToUndo := make([]models.MigrationModel, 0, len(savedMigrations))
+	for i, _ := range migrationsToPlan {
+		identifier := getMigrationIdentifier(migrationsToPlan[i].Version, migrationsToPlan[i].Type)
+		if _, ok := savedIdentifiers[identifier]; ok {
+			migrationsToUndo = append(migrationsToUndo, migrationsToPlan[i])
+		}
+	}
+
+	downgrade := downgradePlanner{
+		manager:         m,
+		savedVersion:    savedVersion,
+		savedMigrations: migrationsToUndo,
+		silent:          true,
+	}
+	status.numberSteps(downgrade.MakePlan(), func(migrationStatus *MigrationStatus, step int) {
+		migrationStatus.UndoStep = step
+	})
+
+	return status, nil
+}
+
+func (s *Status) find(migrationType MigrationType, version string) *MigrationStatus {
+	for i, _ := range s.Migrations {
+		if s.Migrations[i].Type == migrationType && s.Migrations[i].Version == version {
+			return &s.Migrations[i]
+		}
+	}
+	return nil
+}
+
+// numberSteps нумерует миграции плана так же, как их считает migrationsPlan.limitSteps.
+func (s *Status) numberSteps(plan migrationsPlan, set func(migrationStatus *MigrationStatus, step int)) {
+	step := 0
+	for _, migrationModel := range plan.Migrations() {
+		if migrationModel.Type == string(TypeRepeatable) {
+			continue
+		}
+
+		step++
+		if migrationStatus := s.find(MigrationType(migrationModel.Type), migrationModel.Version); migrationStatus != nil {
+			set(migrationStatus, step)
+		}
+	}
+}
+
+func formatStep(step int) string {
+	if step == 0 {
+		return "-"
+	}
+	return strconv.Itoa(step)
+}
+
+func formatStatusTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,126 @@
+package go_migrator
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MashinIvan/go-migrator/internal/models"
+	"github.com/MashinIvan/go-migrator/internal/repository"
+	"strings"
+)
+
+var (
+	ErrValidationFailed         = errors.New("migrations validation failed")
+	ErrAppliedMigrationMissing  = errors.New("applied migration is not registered")
+	ErrDescriptionChanged       = errors.New("migration description differs from saved")
+	ErrNondeterministicChecksum = errors.New("repeatable migration checksum is not deterministic")
+	ErrVersionChanged           = errors.New("migration version differs from version captured at registration")
+)
+
+// ValidationError содержит все проблемы, найденные Validate. Соответствует ErrValidationFailed при проверке через
+// errors.Is.
+type ValidationError struct {
+	Problems []error
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Error())
+	}
+	return fmt.Sprintf("%s: %s", ErrValidationFailed, strings.Join(problems, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidationFailed
+}
+
+// Validate проверяет согласованность зарегистрированных миграций и таблицы migrations, не выполняя миграций и не
+// изменяя базу данных. Проверяются ошибки регистрации, успешно выполненные миграции, отсутствующие в коде, изменившиеся
+// описания, новые миграции с версией ниже сохраненных (*OutOfOrderRegistrationError), недетерминированные контрольные
+// суммы миграций типа TypeRepeatable и версии, изменившиеся после регистрации. Возвращает *ValidationError со всеми
+// найденными проблемами.
+func (m *MigrationManager) Validate() error {
+	problems := make([]error, 0)
+	problems = append(problems, m.registrationErrors...)
+
+	for _, migration := range m.registeredMigrations {
+		version := migration.migrator.Version().String()
+		if version != migration.version {
+			problems = append(problems, fmt.Errorf(
+				"%w: %s %s, current version %s",
+				ErrVersionChanged, migration.migrationType, migration.version, version,
+			))
+		}
+
+		if repeatable, ok := migration.migrator.(RepeatableMigrator); ok && migration.migrationType == TypeRepeatable {
+			first, second := repeatable.Checksum(), repeatable.Checksum()
+			if first != migration.checksum || second != migration.checksum {
+				problems = append(problems, fmt.Errorf(
+					"%w: %s %s, checksums %q, %q, %q",
+					ErrNondeterministicChecksum, migration.migrationType, migration.version,
+					migration.checksum, first, second,
+				))
+			}
+		}
+	}
+
+	if repository.HasMigrationsTable(m.db) {
+		savedMigrations, err := m.loadSavedMigrations(m.db, repository.OrderASC)
+		if err != nil {
+			return err
+		}
+
+		problems = append(problems, m.validateSavedMigrations(savedMigrations)...)
+	}
+
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (m *MigrationManager) validateSavedMigrations(savedMigrations []models.MigrationModel) []error {
+	problems := make([]error, 0)
+
+	for _, migrationModel := range savedMigrations {
+		migration, ok := m.findMigration(migrationModel)
+		if !ok {
+			if migrationModel.State == models.StateSuccess {
+				problems = append(problems, fmt.Errorf(
+					"%w: %s %s", ErrAppliedMigrationMissing, migrationModel.Type, migrationModel.Version,
+				))
+			}
+			continue
+		}
+
+		description := migration.migrator.Description()
+		if description != migrationModel.Description {
+			problems = append(problems, fmt.Errorf(
+				"%w: %s %s, saved %q, registered %q",
+				ErrDescriptionChanged, migrationModel.Type, migrationModel.Version,
+				migrationModel.Description, description,
+			))
+		}
+	}
+
+	for _, migration := range m.registeredMigrations {
+		if !migrationIsNew(migration, savedMigrations) {
+			continue
+		}
+
+		versionToSave := mustParseVersion(migration.version)
+		for _, migrationModel := range savedMigrations {
+			if mustParseVersion(migrationModel.Version).MoreThan(versionToSave) {
+				problems = append(problems, &OutOfOrderRegistrationError{
+					Type:         migration.migrationType,
+					Version:      migration.version,
+					Identifier:   migration.identifier,
+					SavedVersion: migrationModel.Version,
+				})
+				break
+			}
+		}
+	}
+
+	return problems
+}
@@ -1,7 +1,7 @@
 package go_migrator
 
 import (
-	"errors"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -95,7 +95,7 @@ func parseVersion(versionString string) (Version, error) {
 	)
 	match := re.FindStringSubmatch(strings.TrimSpace(versionString))
 	if match == nil {
-		return Version{}, errors.New("version parse failed")
+		return Version{}, fmt.Errorf("%w: %q", ErrInvalidVersion, versionString)
 	}
 
 	major, _ := strconv.Atoi(match[1])
@@ -110,6 +110,8 @@ func parseVersion(versionString string) (Version, error) {
 	}, nil
 }
 
+// mustParseVersion используется только для версий, прошедших проверку: версий зарегистрированных миграций,
+// полученных из Version.String, и сохраненных миграций, проверенных в loadSavedMigrations.
 func mustParseVersion(versionString string) Version {
 	v, err := parseVersion(versionString)
 	if err != nil {